@@ -0,0 +1,362 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// DefaultAccountDeletionGracePeriod is how long a deleted account can still
+// be restored by logging in before it is purged.
+const DefaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+const accountPurgeInterval = time.Hour
+const exportChirpsPageSize = 500
+
+type DeleteAccountRequestBody struct {
+	Password string `json:"password"`
+}
+
+// deleteAccount schedules the account for deletion. The user is logged out
+// everywhere and their personal access tokens are deleted right away. Logging
+// in again during the grace period restores the account.
+func (api *ApiConfig) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	var body DeleteAccountRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	dbUser, err := api.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not delete account. Try again later")
+		return
+	}
+	if dbUser.HashedPassword == "" {
+		RespondWithError(w, http.StatusConflict, "Set a password with a password reset before deleting your account.")
+		return
+	}
+	if body.Password == "" {
+		BadRequestResponse(w, "Password must not be empty.")
+		return
+	}
+	ip := clientIP(r)
+	if retryAfter, ok := api.loginThrottle.reserve(dbUser.Email, ip); !ok {
+		TooManyRequestsResponse(w, retryAfter, "Too many failed attempts. Try again later.")
+		return
+	}
+	if err := api.Passwords.Verify(body.Password, dbUser.HashedPassword); err != nil {
+		ForbiddenResponse(w, "Incorrect password.")
+		return
+	}
+	api.loginThrottle.refundIP(ip)
+	api.loginThrottle.succeed(dbUser.Email)
+
+	deleted, err := api.DB.SoftDeleteUser(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			NotFoundResponse(w, "User not found")
+		} else {
+			InternalServerErrorResponse(w, "Could not delete account. Try again later")
+		}
+		return
+	}
+	api.tokenVersions.set(userID, deleted.TokenVersion)
+	if err := api.DB.RevokeAllSessions(r.Context(), userID); err != nil {
+		slog.Error("Could not revoke sessions of deleted account", "user_id", userID, "error", err)
+	}
+	if err := api.DB.DeleteUserPersonalAccessTokens(r.Context(), userID); err != nil {
+		slog.Error("Could not delete personal tokens of deleted account", "user_id", userID, "error", err)
+	}
+	logSecurityEvent("account_deleted", "user_id", userID)
+	RespondWithJSON(w, http.StatusAccepted, struct {
+		PurgeAt time.Time `json:"purge_at"`
+	}{
+		PurgeAt: deleted.DeletedAt.Time.Add(api.AccountDeletionGracePeriod),
+	})
+}
+
+// restoreAccount cancels a pending deletion. It is called when a deleted user
+// logs in during the grace period.
+func (api *ApiConfig) restoreAccount(ctx context.Context, dbUser database.User) (database.User, error) {
+	restored, err := api.DB.RestoreUser(ctx, dbUser.ID)
+	if err != nil {
+		return dbUser, err
+	}
+	logSecurityEvent("account_restored", "user_id", dbUser.ID)
+	return restored, nil
+}
+
+// purgeDeletedAccounts removes accounts whose grace period ended. Deleting a
+// user cascades to their chirps, sessions and every other row they own; only
+// their uploaded files need to be removed by hand.
+func (api *ApiConfig) purgeDeletedAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deletedBefore := time.Now().UTC().Add(-api.AccountDeletionGracePeriod)
+		userIDs, err := api.DB.ListUsersToPurge(ctx, deletedBefore)
+		if err != nil {
+			slog.Error("Could not list accounts to purge", "error", err)
+		}
+		for _, userID := range userIDs {
+			if err := api.purgeAccount(ctx, userID, deletedBefore); err != nil {
+				slog.Error("Could not purge account", "user_id", userID, "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (api *ApiConfig) purgeAccount(ctx context.Context, userID uuid.UUID, deletedBefore time.Time) error {
+	mediaKeys, err := api.DB.ListUserMediaKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	purged, err := api.DB.PurgeUser(ctx, database.PurgeUserParams{
+		ID:            userID,
+		DeletedBefore: deletedBefore,
+	})
+	if err != nil || purged == 0 {
+		// The user may have restored the account in the meantime.
+		return err
+	}
+	for _, media := range mediaKeys {
+		api.deleteMediaFiles(ctx, media.StorageKey, media.ThumbnailKey)
+	}
+	slog.Info("Purged deleted account", "user_id", userID)
+	return nil
+}
+
+type exportChirp struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Body      string     `json:"body"`
+	ReplyTo   *uuid.UUID `json:"reply_to"`
+}
+
+// accountExport holds the parts of an export that are small enough to load
+// at once. Chirps are streamed page by page as they are written.
+type accountExport struct {
+	Profile    User
+	Sessions   []outputSession
+	Identities []outputIdentity
+	Tokens     []outputPersonalToken
+}
+
+// exportFile is one file of the ZIP archive, or one key of the JSON document
+// named like the file without its extension. write renders the content as
+// JSON, indented with indent unless it is empty.
+type exportFile struct {
+	name  string
+	write func(w io.Writer, indent string) error
+}
+
+// exportAccount sends everything we keep about the user. It answers with a
+// ZIP archive of JSON files, or with a single JSON document for
+// ?format=json. Chirps are streamed, so the export is never held in memory
+// as a whole.
+func (api *ApiConfig) exportAccount(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		BadRequestResponse(w, "format must be zip or json")
+		return
+	}
+	export, err := api.collectAccountExport(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not export account. Try again later")
+		return
+	}
+	files := []exportFile{
+		{"profile.json", encodeExportJSON(export.Profile)},
+		{"chirps.json", func(w io.Writer, indent string) error {
+			return api.writeExportChirps(r.Context(), w, userID, indent)
+		}},
+		{"sessions.json", encodeExportJSON(export.Sessions)},
+		{"identities.json", encodeExportJSON(export.Identities)},
+		{"personal_access_tokens.json", encodeExportJSON(export.Tokens)},
+	}
+	w.Header().Set("cache-control", "no-store")
+	if format == "json" {
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("content-disposition", `attachment; filename="chirpy-export.json"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeExportDocument(w, files)
+	} else {
+		w.Header().Set("content-type", "application/zip")
+		w.Header().Set("content-disposition", `attachment; filename="chirpy-export.zip"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeExportArchive(w, files)
+	}
+	if err != nil {
+		// The status is already sent: all we can do is cut the export short.
+		slog.Error("Could not write account export", "user_id", userID, "error", err)
+	}
+}
+
+func writeExportArchive(w io.Writer, files []exportFile) error {
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err := file.write(writer, "  "); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func writeExportDocument(w io.Writer, files []exportFile) error {
+	separator := "{"
+	for _, file := range files {
+		key, _ := json.Marshal(strings.TrimSuffix(file.name, ".json"))
+		if _, err := fmt.Fprintf(w, "%s%s:", separator, key); err != nil {
+			return err
+		}
+		if err := file.write(w, ""); err != nil {
+			return err
+		}
+		separator = ","
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+func encodeExportJSON(value any) func(w io.Writer, indent string) error {
+	return func(w io.Writer, indent string) error {
+		return writeJSON(w, value, "", indent)
+	}
+}
+
+func writeJSON(w io.Writer, value any, prefix, indent string) error {
+	var data []byte
+	var err error
+	if indent == "" {
+		data, err = json.Marshal(value)
+	} else {
+		data, err = json.MarshalIndent(value, prefix, indent)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeExportChirps writes the chirps of the user as a JSON array, one page
+// at a time.
+func (api *ApiConfig) writeExportChirps(ctx context.Context, w io.Writer, userID uuid.UUID, indent string) error {
+	newline := ""
+	if indent != "" {
+		newline = "\n" + indent
+	}
+	written := 0
+	params := database.ListChirpsAscParams{
+		AuthorID:  uuid.NullUUID{UUID: userID, Valid: true},
+		PageLimit: exportChirpsPageSize,
+	}
+	for {
+		chirps, err := api.DB.ListChirpsAsc(ctx, params)
+		if err != nil {
+			return err
+		}
+		for _, chirp := range chirps {
+			separator := ","
+			if written == 0 {
+				separator = "["
+			}
+			if _, err := io.WriteString(w, separator+newline); err != nil {
+				return err
+			}
+			err := writeJSON(w, exportChirp{
+				ID:        chirp.ID,
+				CreatedAt: chirp.CreatedAt,
+				UpdatedAt: chirp.UpdatedAt,
+				Body:      chirp.Body,
+				ReplyTo:   nullableUUID(chirp.ReplyTo),
+			}, indent, indent)
+			if err != nil {
+				return err
+			}
+			written++
+		}
+		if len(chirps) < exportChirpsPageSize {
+			break
+		}
+		last := chirps[len(chirps)-1]
+		params.CursorCreatedAt = sql.NullTime{Time: last.CreatedAt, Valid: true}
+		params.CursorID = uuid.NullUUID{UUID: last.ID, Valid: true}
+	}
+	closing := "\n]"
+	if written == 0 {
+		closing = "[]"
+	} else if indent == "" {
+		closing = "]"
+	}
+	_, err := io.WriteString(w, closing)
+	return err
+}
+
+// collectAccountExport loads everything but the chirps.
+func (api *ApiConfig) collectAccountExport(ctx context.Context, userID uuid.UUID) (accountExport, error) {
+	dbUser, err := api.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return accountExport{}, err
+	}
+	export := accountExport{
+		Profile:    newUser(dbUser),
+		Sessions:   []outputSession{},
+		Identities: []outputIdentity{},
+		Tokens:     []outputPersonalToken{},
+	}
+	sessions, err := api.DB.ListSessions(ctx, userID)
+	if err != nil {
+		return accountExport{}, err
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, outputSession{
+			ID:         session.FamilyID,
+			SignedInAt: session.SignedInAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IpAddress,
+		})
+	}
+	identities, err := api.DB.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return accountExport{}, err
+	}
+	for _, identity := range identities {
+		export.Identities = append(export.Identities, newIdentity(identity))
+	}
+	tokens, err := api.DB.ListPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		return accountExport{}, err
+	}
+	for _, token := range tokens {
+		export.Tokens = append(export.Tokens, newPersonalToken(token))
+	}
+	return export, nil
+}
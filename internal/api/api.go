@@ -1,23 +1,62 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"log/slog"
 	"sync/atomic"
+	"time"
 
 	"encoding/json"
 	"net/http"
 
+	"github.com/JP-Go/http-server-go/internal/auth"
 	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/mailer"
+	"github.com/JP-Go/http-server-go/internal/media"
+	"github.com/JP-Go/http-server-go/internal/oidc"
+	"github.com/JP-Go/http-server-go/internal/password"
+	"github.com/JP-Go/http-server-go/internal/profanity"
+	"github.com/JP-Go/http-server-go/internal/throttle"
+	"github.com/JP-Go/http-server-go/internal/webhook"
 	"github.com/google/uuid"
 )
 
 type ApiConfig struct {
-	serverHits  atomic.Int32
-	DB          *database.Queries
-	JwtSecret   string
-	PolkaApiKey string
+	DB *database.Queries
+	// Conn is the connection DB runs its queries on. Changes that span
+	// several queries run in a transaction opened on it.
+	Conn    *sql.DB
+	Media   media.Storage
+	Keys    *auth.KeyRing
+	Mailer  mailer.Mailer
+	BaseURL string
+	// PolkaWebhooks checks the signatures of Polka deliveries.
+	PolkaWebhooks *webhook.Verifier
+	// OIDCProviders are the identity providers users can log in with, by
+	// name.
+	OIDCProviders map[string]*oidc.Provider
+	// AccountDeletionGracePeriod defaults to
+	// DefaultAccountDeletionGracePeriod.
+	AccountDeletionGracePeriod time.Duration
+
+	// Policies left empty fall back to their defaults: argon2id with
+	// auth.DefaultArgon2Params, password.DefaultPolicy and the default login
+	// throttling policies.
+	Passwords          *auth.PasswordHasher
+	PasswordPolicy     password.Policy
+	AccountLoginPolicy throttle.Policy
+	IPLoginPolicy      throttle.Policy
+
+	serverHits      atomic.Int32
+	profanityFilter atomic.Pointer[profanity.Filter]
+	tokenVersions   *revocationCache[int32]
+	activeSessions  *revocationCache[bool]
+	loginThrottle   *loginThrottle
+	// resetThrottle counts password reset emails rather than failures.
+	resetThrottle *loginThrottle
 }
 
 type Api struct {
@@ -30,8 +69,46 @@ type errorMessage struct {
 
 func NewApi(apiConfig *ApiConfig) *Api {
 	apiConfig.serverHits.Store(0)
+	apiConfig.profanityFilter.Store(profanity.WordFilter(defaultProfaneWords, profanity.DefaultReplacement))
+	apiConfig.tokenVersions = newRevocationCache[int32](tokenVersionTTL)
+	apiConfig.activeSessions = newRevocationCache[bool](tokenVersionTTL)
+	if apiConfig.AccountLoginPolicy == (throttle.Policy{}) {
+		apiConfig.AccountLoginPolicy = DefaultAccountLoginPolicy
+	}
+	if apiConfig.IPLoginPolicy == (throttle.Policy{}) {
+		apiConfig.IPLoginPolicy = DefaultIPLoginPolicy
+	}
+	if apiConfig.PasswordPolicy.MinLength == 0 && apiConfig.PasswordPolicy.MaxBytes == 0 {
+		apiConfig.PasswordPolicy = password.DefaultPolicy
+	}
+	apiConfig.loginThrottle = newLoginThrottle(apiConfig.AccountLoginPolicy, apiConfig.IPLoginPolicy)
+	apiConfig.resetThrottle = newLoginThrottle(passwordResetPolicy, passwordResetIPPolicy)
+	if apiConfig.AccountDeletionGracePeriod == 0 {
+		apiConfig.AccountDeletionGracePeriod = DefaultAccountDeletionGracePeriod
+	}
+	if apiConfig.PolkaWebhooks == nil {
+		apiConfig.PolkaWebhooks = webhook.NewVerifier(nil, webhook.DefaultTolerance)
+	}
+	if apiConfig.Passwords == nil {
+		apiConfig.Passwords = auth.NewArgon2Hasher(auth.DefaultArgon2Params)
+	}
 	return &Api{config: apiConfig}
 }
+
+// inTx runs fn with queries bound to a transaction, which is committed when fn
+// returns nil and rolled back otherwise.
+func (api *ApiConfig) inTx(ctx context.Context, fn func(queries *database.Queries) error) error {
+	tx, err := api.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(api.DB.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func parseUserIDFromRequest(r *http.Request) uuid.UUID {
 	return uuid.MustParse(r.Context().Value(userIDKey).(string))
 }
@@ -42,26 +119,71 @@ func (api *Api) RegisterEndpoints(fileServer http.Handler, server *http.ServeMux
 	adminRoutes := http.NewServeMux()
 	adminRoutes.HandleFunc("GET /metrics", api.config.metrics)
 	adminRoutes.HandleFunc("POST /reset", api.config.resetMetrics)
-	adminRoutes.HandleFunc("GET /api/healthz", readiness)
+	adminRoutes.HandleFunc("GET /profanity", api.config.listProfanityRules)
+	adminRoutes.HandleFunc("POST /profanity", api.config.createProfanityRule)
+	adminRoutes.HandleFunc("DELETE /profanity/{ruleID}", api.config.deleteProfanityRule)
+	adminRoutes.HandleFunc("PUT /users/{userID}/role", api.config.setUserRole)
+	adminRoutes.HandleFunc("GET /audit-log", api.config.listAuditLog)
 
 	apiRoutes := http.NewServeMux()
 	apiRoutes.HandleFunc("GET /chirps", api.config.getChirps)
 	apiRoutes.HandleFunc("POST /users", api.config.createUser)
 	apiRoutes.HandleFunc("GET /chirps/{chirpID}", api.config.getChirp)
+	apiRoutes.HandleFunc("GET /chirps/{chirpID}/replies", api.config.getReplies)
+	apiRoutes.HandleFunc("GET /chirps/{chirpID}/revisions", api.config.getChirpRevisions)
+	apiRoutes.HandleFunc("GET /hashtags/trending", api.config.getTrendingHashtags)
+	apiRoutes.HandleFunc("GET /hashtags/{tag}/chirps", api.config.getHashtagChirps)
+	apiRoutes.HandleFunc("GET /users/{handleOrID}", api.config.getProfile)
+	apiRoutes.HandleFunc("GET /users/{userID}/followers", api.config.getFollowers)
+	apiRoutes.HandleFunc("GET /users/{userID}/following", api.config.getFollowing)
 
 	apiRoutes.HandleFunc("POST /login", api.config.login)
+	apiRoutes.HandleFunc("POST /login/mfa", api.config.loginMFA)
+	apiRoutes.HandleFunc("POST /users/verify", api.config.verifyEmail)
+	apiRoutes.HandleFunc("POST /password/forgot", api.config.forgotPassword)
+	apiRoutes.HandleFunc("POST /password/reset", api.config.resetPassword)
+	apiRoutes.HandleFunc("GET /auth/{provider}/start", api.config.startOIDC)
+	apiRoutes.HandleFunc("GET /auth/{provider}/callback", api.config.oidcCallback)
 	apiRoutes.HandleFunc("POST /refresh", api.config.refreshAccessToken)
 	apiRoutes.HandleFunc("POST /revoke", api.config.revokeRefreshToken)
 	apiRoutes.HandleFunc("POST /polka/webhooks", api.config.polkaUpgradeToChirpyRed)
 
 	loggedInRoutes := http.NewServeMux()
 
-	loggedInRoutes.Handle("DELETE /chirps/{chirpID}", http.HandlerFunc(api.config.deleteChirp))
-	loggedInRoutes.Handle("POST /chirps", http.HandlerFunc(api.config.createChirp))
-	loggedInRoutes.Handle("PUT /users", http.HandlerFunc(api.config.updateUser))
+	loggedInRoutes.Handle("DELETE /chirps/{chirpID}", requireScope(auth.ScopeChirpsDelete, http.HandlerFunc(api.config.deleteChirp)))
+	loggedInRoutes.Handle("POST /chirps", requireScope(auth.ScopeChirpsWrite, http.HandlerFunc(api.config.createChirp)))
+	loggedInRoutes.Handle("PATCH /chirps/{chirpID}", requireScope(auth.ScopeChirpsWrite, http.HandlerFunc(api.config.editChirp)))
+	loggedInRoutes.Handle("POST /media", requireScope(auth.ScopeChirpsWrite, http.HandlerFunc(api.config.uploadMedia)))
+	loggedInRoutes.Handle("PUT /chirps/{chirpID}/like", requireScope(auth.ScopeSocialWrite, http.HandlerFunc(api.config.likeChirp)))
+	loggedInRoutes.Handle("DELETE /chirps/{chirpID}/like", requireScope(auth.ScopeSocialWrite, http.HandlerFunc(api.config.unlikeChirp)))
+	loggedInRoutes.Handle("PUT /chirps/{chirpID}/repost", requireScope(auth.ScopeSocialWrite, http.HandlerFunc(api.config.repostChirp)))
+	loggedInRoutes.Handle("DELETE /chirps/{chirpID}/repost", requireScope(auth.ScopeSocialWrite, http.HandlerFunc(api.config.undoRepostChirp)))
+	loggedInRoutes.Handle("PUT /users", requireScope(auth.ScopeProfileWrite, http.HandlerFunc(api.config.updateUser)))
+	loggedInRoutes.Handle("POST /users/verify/resend", requireLogin(http.HandlerFunc(api.config.resendVerificationEmail)))
+	loggedInRoutes.Handle("POST /users/{userID}/follow", requireScope(auth.ScopeSocialWrite, http.HandlerFunc(api.config.followUser)))
+	loggedInRoutes.Handle("DELETE /users/{userID}/follow", requireScope(auth.ScopeSocialWrite, http.HandlerFunc(api.config.unfollowUser)))
+	loggedInRoutes.Handle("GET /timeline", requireScope(auth.ScopeChirpsRead, http.HandlerFunc(api.config.getTimeline)))
+	loggedInRoutes.Handle("GET /users/me/mentions", requireScope(auth.ScopeChirpsRead, http.HandlerFunc(api.config.getMyMentions)))
+	loggedInRoutes.Handle("GET /sessions", requireLogin(http.HandlerFunc(api.config.listSessions)))
+	loggedInRoutes.Handle("DELETE /sessions/{sessionID}", requireLogin(http.HandlerFunc(api.config.revokeSession)))
+	loggedInRoutes.Handle("POST /sessions/revoke-all", requireLogin(http.HandlerFunc(api.config.revokeAllSessions)))
+	loggedInRoutes.Handle("POST /users/me/totp", requireLogin(http.HandlerFunc(api.config.startTOTPEnrollment)))
+	loggedInRoutes.Handle("POST /users/me/totp/verify", requireLogin(http.HandlerFunc(api.config.verifyTOTPEnrollment)))
+	loggedInRoutes.Handle("DELETE /users/me/totp", requireLogin(http.HandlerFunc(api.config.disableTOTP)))
+	loggedInRoutes.Handle("POST /auth/{provider}/link", requireLogin(http.HandlerFunc(api.config.linkOIDC)))
+	loggedInRoutes.Handle("GET /users/me/identities", requireLogin(http.HandlerFunc(api.config.listIdentities)))
+	loggedInRoutes.Handle("DELETE /users/me/identities/{provider}", requireLogin(http.HandlerFunc(api.config.unlinkIdentity)))
+	loggedInRoutes.Handle("DELETE /users/me", requireLogin(http.HandlerFunc(api.config.deleteAccount)))
+	loggedInRoutes.Handle("GET /users/me/export", requireLogin(http.HandlerFunc(api.config.exportAccount)))
+	loggedInRoutes.Handle("GET /tokens", requireLogin(http.HandlerFunc(api.config.listPersonalTokens)))
+	loggedInRoutes.Handle("POST /tokens", requireLogin(http.HandlerFunc(api.config.createPersonalToken)))
+	loggedInRoutes.Handle("DELETE /tokens/{tokenID}", requireLogin(http.HandlerFunc(api.config.deletePersonalToken)))
 	apiRoutes.Handle("/", api.config.loggedInMiddleware(loggedInRoutes))
 
-	server.Handle("/admin/", http.StripPrefix("/admin", adminRoutes))
+	server.HandleFunc("GET /.well-known/jwks.json", api.config.jwks)
+	// Health checks come from load balancers, which have no admin account.
+	server.HandleFunc("GET /admin/api/healthz", readiness)
+	server.Handle("/admin/", http.StripPrefix("/admin", api.config.loggedInMiddleware(requireLogin(requireRole(auth.RoleAdmin, adminRoutes)))))
 	server.Handle("/api/", http.StripPrefix("/api", apiRoutes))
 
 }
@@ -71,6 +193,9 @@ func (api *Api) Serve(mux *http.ServeMux, port int) {
 		Handler: mux,
 		Addr:    fmt.Sprintf(":%d", port),
 	}
+	go api.config.watchProfanityRules(context.Background(), profanityReloadInterval)
+	go api.config.purgeDeletedAccounts(context.Background(), accountPurgeInterval)
+	go api.config.sweepUnattachedMedia(context.Background(), mediaSweepInterval)
 	slog.Info(fmt.Sprintf("Server running on port :%d", port))
 	err := server.ListenAndServe()
 	if err != nil {
@@ -0,0 +1,153 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/JP-Go/http-server-go/internal/api"
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/mailer"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testBaseURL = "http://chirpy.test"
+
+// userColumns are the columns of every query that returns a users row.
+var userColumns = []string{"id", "created_at", "updated_at", "email", "hashed_password", "is_chirpy_red", "handle", "display_name", "bio", "avatar_url", "token_version", "email_verified_at", "role", "deleted_at"}
+
+// captureMailer hands the messages it is asked to send to the test.
+type captureMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+// receive waits for the next message, which handlers send in the background.
+func (m *captureMailer) receive(t *testing.T) mailer.Message {
+	t.Helper()
+	select {
+	case msg := <-m.sent:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("No email was sent")
+		return mailer.Message{}
+	}
+}
+
+type testServer struct {
+	mux    *http.ServeMux
+	db     sqlmock.Sqlmock
+	mailer *captureMailer
+	config *api.ApiConfig
+}
+
+// newTestServer registers the endpoints on a mocked database. Queries are
+// expected by the name sqlc gives them, for example expectQuery("GetUserByID").
+// The /app/ file server serves the repository root, like main does.
+func newTestServer(t *testing.T, configure func(cfg *api.ApiConfig)) *testServer {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("Could not create mock database: %s", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	capture := &captureMailer{sent: make(chan mailer.Message, 8)}
+	cfg := &api.ApiConfig{
+		DB:        database.New(db),
+		Conn:      db,
+		Keys:      auth.NewHMACKeyRing("secret"),
+		Mailer:    capture,
+		BaseURL:   testBaseURL,
+		Passwords: auth.NewBcryptHasher(bcrypt.MinCost),
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	mux := http.NewServeMux()
+	fileServer := http.StripPrefix("/app", http.FileServer(http.Dir("../..")))
+	api.NewApi(cfg).RegisterEndpoints(fileServer, mux)
+	return &testServer{mux: mux, db: mock, mailer: capture, config: cfg}
+}
+
+func queryName(name string) string {
+	return regexp.QuoteMeta("-- name: " + name + " ")
+}
+
+func (s *testServer) expectQuery(name string) *sqlmock.ExpectedQuery {
+	return s.db.ExpectQuery(queryName(name))
+}
+
+func (s *testServer) expectExec(name string) *sqlmock.ExpectedExec {
+	return s.db.ExpectExec(queryName(name))
+}
+
+// checkExpectations fails the test when a query that was expected did not
+// run. Call it once every response has been read.
+func (s *testServer) checkExpectations(t *testing.T) {
+	t.Helper()
+	if err := s.db.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// do serves one request. A non nil body is sent as JSON.
+func (s *testServer) do(method, target, bearer string, body any) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	r := httptest.NewRequest(method, target, &payload)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if bearer != "" {
+		r.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+// userRow returns the users row of user, as the database would.
+func userRow(user database.User) *sqlmock.Rows {
+	return sqlmock.NewRows(userColumns).AddRow(userValues(user)...)
+}
+
+func userValues(user database.User) []driver.Value {
+	var verifiedAt, deletedAt driver.Value
+	if user.EmailVerifiedAt.Valid {
+		verifiedAt = user.EmailVerifiedAt.Time
+	}
+	if user.DeletedAt.Valid {
+		deletedAt = user.DeletedAt.Time
+	}
+	return []driver.Value{user.ID, user.CreatedAt, user.UpdatedAt, user.Email, user.HashedPassword, user.IsChirpyRed, user.Handle, user.DisplayName, user.Bio, user.AvatarUrl, user.TokenVersion, verifiedAt, user.Role, deletedAt}
+}
+
+func newTestUser() database.User {
+	now := time.Now().UTC()
+	return database.User{
+		ID:           uuid.New(),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Email:        "walt@example.com",
+		Handle:       "walt",
+		TokenVersion: 1,
+		Role:         auth.RoleUser,
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditDeleteChirp = "delete_chirp"
+	auditSetRole     = "set_role"
+)
+
+const defaultAuditLogLimit = 50
+const maxAuditLogLimit = 500
+
+type outputAuditLogEntry struct {
+	ID           uuid.UUID  `json:"id"`
+	ActorID      *uuid.UUID `json:"actor_id"`
+	Action       string     `json:"action"`
+	TargetUserID *uuid.UUID `json:"target_user_id"`
+	ChirpID      *uuid.UUID `json:"chirp_id"`
+	Details      string     `json:"details"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func nullableUUID(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+// audit records an action taken with the privileges of a role.
+func (api *ApiConfig) audit(ctx context.Context, entry database.CreateAuditLogEntryParams) error {
+	logSecurityEvent("privileged_action", "action", entry.Action, "actor_id", entry.ActorID.UUID,
+		"target_user_id", entry.TargetUserID.UUID, "chirp_id", entry.ChirpID.UUID)
+	return api.DB.CreateAuditLogEntry(ctx, entry)
+}
+
+func (api *ApiConfig) listAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAuditLogLimit
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > maxAuditLogLimit {
+			BadRequestResponse(w, "limit must be between 1 and 500")
+			return
+		}
+		limit = parsed
+	}
+	entries, err := api.DB.ListAuditLog(r.Context(), int32(limit))
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not list the audit log. Try again later")
+		return
+	}
+	output := make([]outputAuditLogEntry, len(entries))
+	for i, entry := range entries {
+		output[i] = outputAuditLogEntry{
+			ID:           entry.ID,
+			ActorID:      nullableUUID(entry.ActorID),
+			Action:       entry.Action,
+			TargetUserID: nullableUUID(entry.TargetUserID),
+			ChirpID:      nullableUUID(entry.ChirpID),
+			Details:      entry.Details,
+			CreatedAt:    entry.CreatedAt,
+		}
+	}
+	OkResponse(w, output)
+}
+
+type SetRoleRequestBody struct {
+	Role string `json:"role"`
+}
+
+// setUserRole changes the role of a user. The new role takes effect at once:
+// the change bumps the token version, logging the user out.
+func (api *ApiConfig) setUserRole(w http.ResponseWriter, r *http.Request) {
+	adminID := parseUserIDFromRequest(r)
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid userID")
+		return
+	}
+	var body SetRoleRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	if !auth.ValidRole(body.Role) {
+		BadRequestResponse(w, "Invalid role")
+		return
+	}
+	if userID == adminID {
+		BadRequestResponse(w, "You cannot change your own role")
+		return
+	}
+	err = api.audit(r.Context(), database.CreateAuditLogEntryParams{
+		ActorID:      uuid.NullUUID{UUID: adminID, Valid: true},
+		Action:       auditSetRole,
+		TargetUserID: uuid.NullUUID{UUID: userID, Valid: true},
+		Details:      body.Role,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not change role. Try again later")
+		return
+	}
+	user, err := api.DB.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: body.Role,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			NotFoundResponse(w, "User not found")
+		} else {
+			InternalServerErrorResponse(w, "Could not change role. Try again later")
+		}
+		return
+	}
+	api.tokenVersions.set(user.ID, user.TokenVersion)
+	OkResponse(w, newUser(user))
+}
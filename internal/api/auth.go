@@ -1,20 +1,23 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/JP-Go/http-server-go/internal/auth"
 	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
 )
 
 const defaultAccessTokenTTL = time.Hour
 const defaultRefreshTokenTTL = time.Hour * 24 * 60
 
-type LoginRequestBody = CreateUserRequestBody
+type LoginRequestBody = CredentialsRequestBody
 
 type LoginResponseBody struct {
 	User
@@ -37,60 +40,129 @@ func (cfg *ApiConfig) login(w http.ResponseWriter, r *http.Request) {
 		BadRequestResponse(w, "Password must not be empty.")
 		return
 	}
+	ip := clientIP(r)
+	if retryAfter, ok := cfg.loginThrottle.reserve(body.Email, ip); !ok {
+		TooManyRequestsResponse(w, retryAfter, "Too many failed login attempts. Try again later.")
+		return
+	}
 	dbUser, err := cfg.DB.GetUserByEmail(r.Context(), body.Email)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			UnauthorizedResponse(w, "Invalid email or password.")
-			return
-		}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
 	}
-	err = auth.VerifyPassword(body.Password, dbUser.HashedPassword)
-	if err != nil {
+	userFound := err == nil
+	// Unknown emails and every kind of stored hash take the same time, so
+	// the response does not tell which accounts exist.
+	err = cfg.Passwords.VerifyUniform(body.Password, dbUser.HashedPassword)
+	if err != nil || !userFound {
 		UnauthorizedResponse(w, "Invalid email or password.")
 		return
 	}
-	token, err := auth.MakeJWT(dbUser.ID, cfg.JwtSecret, defaultAccessTokenTTL)
+	cfg.loginThrottle.refundIP(ip)
+	if cfg.Passwords.NeedsRehash(dbUser.HashedPassword) {
+		cfg.rehashPassword(r.Context(), dbUser, body.Password)
+	}
+	cfg.completeLogin(w, r, dbUser)
+}
 
+// completeLogin asks for the second factor of users that enabled one and
+// starts a session for everyone else. The account throttle keeps counting
+// the login until the second factor is checked too.
+func (cfg *ApiConfig) completeLogin(w http.ResponseWriter, r *http.Request, dbUser database.User) {
+	mfaRequired, err := cfg.totpEnabled(r.Context(), dbUser.ID)
 	if err != nil {
-		UnauthorizedResponse(w, "Invalid email or password.")
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	if mfaRequired {
+		cfg.sendMFAChallenge(w, dbUser.ID)
 		return
 	}
+	cfg.loginThrottle.succeed(dbUser.Email)
+	cfg.startSession(w, r, dbUser)
+}
 
-	refreshToken, err := auth.MakeRefreshToken()
+// rehashPassword upgrades the stored hash to the current algorithm and
+// parameters. It is only possible right after a login, while we know the
+// password, and a failure does not stop the login.
+func (cfg *ApiConfig) rehashPassword(ctx context.Context, dbUser database.User, password string) {
+	hashedPassword, err := cfg.Passwords.Hash(password)
+	if err == nil {
+		err = cfg.DB.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+			ID:      dbUser.ID,
+			OldHash: dbUser.HashedPassword,
+			NewHash: hashedPassword,
+		})
+	}
+	if err != nil {
+		slog.Error("Could not rehash password", "user_id", dbUser.ID, "error", err)
+	}
+}
+
+// startSession answers a successful login with a new token family. Logging in
+// to an account scheduled for deletion restores it.
+func (cfg *ApiConfig) startSession(w http.ResponseWriter, r *http.Request, dbUser database.User) {
+	if dbUser.DeletedAt.Valid {
+		var err error
+		if dbUser, err = cfg.restoreAccount(r.Context(), dbUser); err != nil {
+			InternalServerErrorResponse(w, "Error on login. Try again later.")
+			return
+		}
+	}
+	sessionID := uuid.New()
+	token, err := cfg.Keys.MakeJWT(dbUser.ID, sessionID, dbUser.TokenVersion, dbUser.Role, defaultAccessTokenTTL)
 	if err != nil {
 		InternalServerErrorResponse(w, "Error on login. Try again later.")
 		return
 	}
-	_, err = cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:     refreshToken,
-		UserID:    dbUser.ID,
-		ExpiresAt: time.Now().UTC().Add(defaultRefreshTokenTTL),
-	})
+
+	refreshToken, err := issueRefreshToken(r, cfg.DB, dbUser.ID, sessionID, time.Now().UTC().Add(defaultRefreshTokenTTL))
 	if err != nil {
 		InternalServerErrorResponse(w, "Error on login. Try again later.")
 		return
 	}
 
 	OkResponse(w, LoginResponseBody{
-		User: User{
-			ID:          dbUser.ID,
-			CreatedAt:   dbUser.CreatedAt,
-			UpdatedAt:   dbUser.UpdatedAt,
-			Email:       dbUser.Email,
-			IsChirpyRed: dbUser.IsChirpyRed,
-		},
+		User:         newUser(dbUser),
 		Token:        token,
 		RefreshToken: refreshToken,
 	})
 }
 
+// issueRefreshToken stores a new refresh token of a token family. Every token
+// of a family shares the expiry of the login that created it. The client that
+// made the request is recorded so users can tell their sessions apart.
+func issueRefreshToken(r *http.Request, queries *database.Queries, userID, familyID uuid.UUID, expiresAt time.Time) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = queries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashRefreshToken(refreshToken),
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		FamilyID:  familyID,
+		UserAgent: clientUserAgent(r),
+		IpAddress: clientIP(r),
+	})
+	if err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+var errRefreshTokenRotated = errors.New("Refresh token was already rotated")
+
+// refreshAccessToken exchanges a refresh token for a new access token and a
+// new refresh token. A refresh token can only be used once: presenting one
+// that was already rotated means it leaked, so the whole family is revoked.
 func (api *ApiConfig) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		BadRequestResponse(w, "Missing authentication or authentication type invalid")
 		return
 	}
-	userWithToken, err := api.DB.GetUserFromRefreshToken(r.Context(), refreshToken)
+	userWithToken, err := api.DB.GetUserFromRefreshToken(r.Context(), auth.HashRefreshToken(refreshToken))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			UnauthorizedResponse(w, "User not registered.")
@@ -99,6 +171,11 @@ func (api *ApiConfig) refreshAccessToken(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	if userWithToken.RotatedAt.Valid {
+		api.revokeReusedRefreshToken(r.Context(), userWithToken.ID, userWithToken.FamilyID)
+		UnauthorizedResponse(w, "Session expired")
+		return
+	}
 	if userWithToken.ExpiresAt.Before(time.Now()) {
 		UnauthorizedResponse(w, "Session expired")
 		return
@@ -108,26 +185,61 @@ func (api *ApiConfig) refreshAccessToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := auth.MakeJWT(userWithToken.ID, api.JwtSecret, defaultAccessTokenTTL)
+	token, err := api.Keys.MakeJWT(userWithToken.ID, userWithToken.FamilyID, userWithToken.TokenVersion, userWithToken.Role, defaultAccessTokenTTL)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return
+	}
+	// The old token is only marked rotated if its successor is stored: a
+	// client whose refresh failed can retry without looking like a thief.
+	var newRefreshToken string
+	err = api.inTx(r.Context(), func(queries *database.Queries) error {
+		rotated, err := queries.RotateRefreshToken(r.Context(), userWithToken.TokenHash)
+		if err != nil {
+			return err
+		}
+		if rotated == 0 {
+			return errRefreshTokenRotated
+		}
+		newRefreshToken, err = issueRefreshToken(r, queries, userWithToken.ID, userWithToken.FamilyID, userWithToken.ExpiresAt)
+		return err
+	})
+	if errors.Is(err, errRefreshTokenRotated) {
+		// Another request rotated the token between our read and our update.
+		api.revokeReusedRefreshToken(r.Context(), userWithToken.ID, userWithToken.FamilyID)
+		UnauthorizedResponse(w, "Session expired")
+		return
+	}
 	if err != nil {
 		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
 		return
 	}
 	OkResponse(w, struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}{
-		Token: token,
+		Token:        token,
+		RefreshToken: newRefreshToken,
 	})
 
 }
 
+func (api *ApiConfig) revokeReusedRefreshToken(ctx context.Context, userID, familyID uuid.UUID) {
+	logSecurityEvent("refresh_token_reuse", "user_id", userID, "family_id", familyID)
+	if err := api.DB.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		slog.Error("Could not revoke refresh token family", "family_id", familyID, "error", err)
+		return
+	}
+	api.endSession(familyID)
+}
+
 func (api *ApiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		BadRequestResponse(w, "Missing authentication or authentication type invalid")
 		return
 	}
-	userWithToken, err := api.DB.GetUserFromRefreshToken(r.Context(), refreshToken)
+	userWithToken, err := api.DB.GetUserFromRefreshToken(r.Context(), auth.HashRefreshToken(refreshToken))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			NotFoundResponse(w, "Session not found")
@@ -146,6 +258,18 @@ func (api *ApiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = api.DB.RevokeRefreshToken(r.Context(), refreshToken)
+	err = api.DB.RevokeRefreshToken(r.Context(), userWithToken.TokenHash)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return
+	}
+	// The family only has one live token, so this ends the session.
+	api.endSession(userWithToken.FamilyID)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// jwks publishes the public keys that verify our access tokens.
+func (cfg *ApiConfig) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("cache-control", "public, max-age=300")
+	OkResponse(w, cfg.Keys.JWKS())
+}
@@ -0,0 +1,149 @@
+package api_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+var refreshTokenColumns = append(append([]string{}, userColumns...), "token_hash", "expires_at", "revoked_at", "family_id", "rotated_at")
+
+// refreshTokenRow is the row of GetUserFromRefreshToken for a live token of
+// the session familyID. rotatedAt is nil unless the token was already used.
+func refreshTokenRow(user database.User, familyID uuid.UUID, rotatedAt driver.Value) *sqlmock.Rows {
+	values := append(userValues(user), "hash", time.Now().Add(time.Hour), nil, familyID, rotatedAt)
+	return sqlmock.NewRows(refreshTokenColumns).AddRow(values...)
+}
+
+func sessionRows(sessionIDs ...uuid.UUID) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"family_id", "signed_in_at", "last_used_at", "expires_at", "user_agent", "ip_address"})
+	now := time.Now()
+	for _, id := range sessionIDs {
+		rows.AddRow(id, now, now, now.Add(time.Hour), "test", "192.0.2.1")
+	}
+	return rows
+}
+
+func makeAccessToken(t *testing.T, s *testServer, user database.User, sessionID uuid.UUID) string {
+	t.Helper()
+	token, err := s.config.Keys.MakeJWT(user.ID, sessionID, user.TokenVersion, user.Role, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func Test_RefreshRotatesTheTokenOfTheSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	sessionID := uuid.New()
+	s.expectQuery("GetUserFromRefreshToken").WillReturnRows(refreshTokenRow(user, sessionID, nil))
+	s.db.ExpectBegin()
+	s.expectExec("RotateRefreshToken").WithArgs("hash").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectQuery("CreateRefreshToken").WithArgs(sqlmock.AnyArg(), user.ID, sqlmock.AnyArg(), sessionID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"token_hash"}).AddRow("new-hash"))
+	s.db.ExpectCommit()
+	w := s.do(http.MethodPost, "/api/refresh", "refresh-token", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/refresh = %d, expected %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var body struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	accessToken, err := s.config.Keys.ParseAccessToken(body.Token)
+	if err != nil {
+		t.Fatalf("Refresh returned an invalid access token: %s", err)
+	}
+	if accessToken.SessionID != sessionID {
+		t.Errorf("Access token is for session %s, expected %s", accessToken.SessionID, sessionID)
+	}
+	if body.RefreshToken == "" || body.RefreshToken == "refresh-token" {
+		t.Errorf("Refresh should return a new refresh token, got %q", body.RefreshToken)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_RefreshKeepsTheTokenWhenItsSuccessorIsNotStored(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	s.expectQuery("GetUserFromRefreshToken").WillReturnRows(refreshTokenRow(user, uuid.New(), nil))
+	s.db.ExpectBegin()
+	s.expectExec("RotateRefreshToken").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectQuery("CreateRefreshToken").WillReturnError(sql.ErrConnDone)
+	s.db.ExpectRollback()
+	w := s.do(http.MethodPost, "/api/refresh", "refresh-token", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("POST /api/refresh = %d, expected %d", w.Code, http.StatusInternalServerError)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_ReusedRefreshTokenEndsTheSession(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	sessionID := uuid.New()
+	s.expectQuery("GetUserFromRefreshToken").WillReturnRows(refreshTokenRow(user, sessionID, time.Now()))
+	s.expectExec("RevokeRefreshTokenFamily").WithArgs(sessionID).WillReturnResult(sqlmock.NewResult(0, 2))
+	if w := s.do(http.MethodPost, "/api/refresh", "refresh-token", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("POST /api/refresh = %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Access tokens of the session stop working too, and the other sessions
+	// of the user are left alone.
+	s.expectQuery("GetUserTokenVersion").WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(user.TokenVersion))
+	if w := s.do(http.MethodGet, "/api/sessions", makeAccessToken(t, s, user, sessionID), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/sessions with a token of the ended session = %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+	otherSessionID := uuid.New()
+	s.expectQuery("SessionIsActive").WithArgs(otherSessionID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	s.expectQuery("ListSessions").WillReturnRows(sessionRows(otherSessionID))
+	if w := s.do(http.MethodGet, "/api/sessions", makeAccessToken(t, s, user, otherSessionID), nil); w.Code != http.StatusOK {
+		t.Errorf("GET /api/sessions with a token of another session = %d, expected %d", w.Code, http.StatusOK)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_RevokingASessionRejectsItsAccessTokens(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	sessionID := uuid.New()
+	token := makeAccessToken(t, s, user, sessionID)
+
+	s.expectQuery("GetUserTokenVersion").WithArgs(user.ID).WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(user.TokenVersion))
+	s.expectQuery("SessionIsActive").WithArgs(sessionID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	s.expectQuery("ListSessions").WithArgs(user.ID).WillReturnRows(sessionRows(sessionID))
+	if w := s.do(http.MethodGet, "/api/sessions", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/sessions = %d, expected %d", w.Code, http.StatusOK)
+	}
+
+	s.expectExec("RevokeSession").WithArgs(sessionID, user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	if w := s.do(http.MethodDelete, "/api/sessions/"+sessionID.String(), token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /api/sessions/%s = %d, expected %d", sessionID, w.Code, http.StatusNoContent)
+	}
+
+	if w := s.do(http.MethodGet, "/api/sessions", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/sessions after revoking the session = %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_AccessTokenOfAnExpiredSessionIsRejected(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	sessionID := uuid.New()
+	s.expectQuery("GetUserTokenVersion").WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(user.TokenVersion))
+	s.expectQuery("SessionIsActive").WithArgs(sessionID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	if w := s.do(http.MethodGet, "/api/sessions", makeAccessToken(t, s, user, sessionID), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/sessions = %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+	s.checkExpectations(t)
+}
@@ -1,22 +1,23 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http"
-	"slices"
-	"strings"
 	"time"
 
+	"github.com/JP-Go/http-server-go/internal/auth"
 	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/profanity"
 	"github.com/google/uuid"
 )
 
 const maxChirpLength = 140
-const profanityReplacement = "****"
 
-var profaneWords = []string{"kerfuffle", "sharbert", "fornax"}
+// defaultProfaneWords is used until the rules stored in the database are loaded.
+var defaultProfaneWords = []string{"kerfuffle", "sharbert", "fornax"}
 
 type ValidChirp struct {
 	Chirp
@@ -41,30 +42,39 @@ func ValidateChirp(chirp Chirp) (ValidChirp, error) {
 	return ValidChirp{Chirp: chirp, Valid: true}, nil
 }
 
-func CleanChirp(chirp ValidChirp, profaneWords []string, replacer string) (ValidChirp, error) {
+func CleanChirp(chirp ValidChirp, filter *profanity.Filter) (ValidChirp, error) {
 	if !chirp.Valid {
 		return chirp, errors.New("Cannot clean an invalid chirp")
 	}
-	words := strings.Split(chirp.content, " ")
-	for i, word := range words {
-		if slices.Contains(profaneWords, strings.ToLower(word)) {
-			words[i] = profanityReplacement
-		}
+	cleanedContent := filter.Clean(chirp.content)
+	// Replacements can be longer than what they replace.
+	if len(cleanedContent) > maxChirpLength {
+		return ValidChirp{Valid: false}, errors.New("Chirp too long once censored")
 	}
-	cleanedContent := strings.Join(words, " ")
 	return ValidChirp{Valid: true, Chirp: NewChirp(cleanedContent)}, nil
 }
 
 type inputChirp struct {
-	Body string `json:"body"`
+	Body     string      `json:"body"`
+	ReplyTo  *uuid.UUID  `json:"reply_to"`
+	MediaIDs []uuid.UUID `json:"media_ids"`
 }
 
 type outputChirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID          uuid.UUID      `json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	Body        string         `json:"body"`
+	UserID      uuid.UUID      `json:"user_id"`
+	Author      *outputAuthor  `json:"author"`
+	ReplyTo     *uuid.UUID     `json:"reply_to"`
+	Deleted     bool           `json:"deleted,omitempty"`
+	LikeCount   int64          `json:"like_count"`
+	RepostCount int64          `json:"repost_count"`
+	ReplyCount  int64          `json:"reply_count"`
+	LikedByMe   bool           `json:"liked_by_me"`
+	Media       []outputMedia  `json:"media"`
+	Entities    outputEntities `json:"entities"`
 }
 
 func (api *ApiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
@@ -82,7 +92,7 @@ func (api *ApiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		BadRequestResponse(w, err.Error())
 		return
 	}
-	validChirp, err = CleanChirp(validChirp, profaneWords, profanityReplacement)
+	validChirp, err = CleanChirp(validChirp, api.profanityFilter.Load())
 	if err != nil {
 		BadRequestResponse(w, err.Error())
 		return
@@ -97,65 +107,208 @@ func (api *ApiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	dbChirp, err := api.DB.CreateChirp(r.Context(), database.CreateChirpParams{
-		UserID: uuid.NullUUID{
-			UUID:  user.ID,
-			Valid: true,
-		},
-		Body: validChirp.content,
+	var replyTo uuid.NullUUID
+	if chirp.ReplyTo != nil {
+		parent, err := api.DB.FindChirpByID(r.Context(), *chirp.ReplyTo)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				BadRequestResponse(w, "Chirp being replied to does not exist")
+			} else {
+				InternalServerErrorResponse(w, "Unexpected error")
+			}
+			return
+		}
+		if parent.DeletedAt.Valid {
+			BadRequestResponse(w, "Chirp being replied to was deleted")
+			return
+		}
+		replyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+	if err := api.validateMediaIDs(r.Context(), user.ID, chirp.MediaIDs); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	// The chirp, its media and its entities are saved together, so a failure
+	// leaves nothing behind and the client can safely retry.
+	var dbChirp database.Chirp
+	err = api.inTx(r.Context(), func(queries *database.Queries) error {
+		dbChirp, err = queries.CreateChirp(r.Context(), database.CreateChirpParams{
+			UserID: uuid.NullUUID{
+				UUID:  user.ID,
+				Valid: true,
+			},
+			Body:    validChirp.content,
+			ReplyTo: replyTo,
+		})
+		if err != nil {
+			return err
+		}
+		if err := attachMedia(r.Context(), queries, user.ID, dbChirp.ID, chirp.MediaIDs); err != nil {
+			return err
+		}
+		return saveEntities(r.Context(), queries, dbChirp.ID, dbChirp.Body)
 	})
 	if err != nil {
+		if errors.Is(err, errMediaTaken) {
+			BadRequestResponse(w, err.Error())
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error")
+		}
+		return
+	}
+	output := []outputChirp{newOutputChirp(dbChirp)}
+	if err := api.hydrateChirps(r.Context(), uuid.NullUUID{UUID: user.ID, Valid: true}, output); err != nil {
 		InternalServerErrorResponse(w, "Unexpected error")
 		return
 	}
+	RespondWithJSON(w, http.StatusCreated, output[0])
+}
+
+type outputAuthor struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+type chirpPage struct {
+	Chirps     []outputChirp `json:"chirps"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+func newOutputChirp(chirp database.Chirp) outputChirp {
 	output := outputChirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		Body:      dbChirp.Body,
-		UserID:    dbChirp.UserID.UUID,
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		Body:      chirp.Body,
+		UserID:    chirp.UserID.UUID,
+		Deleted:   chirp.DeletedAt.Valid,
 	}
-	RespondWithJSON(w, http.StatusCreated, output)
+	if chirp.ReplyTo.Valid {
+		replyTo := chirp.ReplyTo.UUID
+		output.ReplyTo = &replyTo
+	}
+	return output
 }
 
-func (api *ApiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
-	authorID := r.URL.Query().Get("author_id")
-	sort := r.URL.Query().Get("sort")
-	var chirps []database.Chirp
-	if authorID == "" {
-		allChirps, err := api.DB.GetChirps(r.Context())
-		if err != nil {
-			InternalServerErrorResponse(w, "Unexpected error")
-			return
+// hydrateChirps loads everything a chirp representation needs besides the
+// chirps row itself, batching the queries for the whole slice.
+func (api *ApiConfig) hydrateChirps(ctx context.Context, viewerID uuid.NullUUID, chirps []outputChirp) error {
+	if err := api.loadEngagement(ctx, viewerID, chirps); err != nil {
+		return err
+	}
+	if err := api.loadMedia(ctx, chirps); err != nil {
+		return err
+	}
+	if err := api.loadAuthors(ctx, chirps); err != nil {
+		return err
+	}
+	return api.loadEntities(ctx, chirps)
+}
+
+func (api *ApiConfig) loadAuthors(ctx context.Context, chirps []outputChirp) error {
+	seen := make(map[uuid.UUID]bool)
+	var ids []uuid.UUID
+	for _, chirp := range chirps {
+		if !seen[chirp.UserID] {
+			seen[chirp.UserID] = true
+			ids = append(ids, chirp.UserID)
 		}
-		chirps = allChirps
-	} else {
-		authorID, err := uuid.Parse(authorID)
-		userChirps, err := api.DB.FindChirpsFromUser(r.Context(), uuid.NullUUID{
-			UUID:  authorID,
-			Valid: true,
-		})
-		if err != nil {
-			InternalServerErrorResponse(w, "Unexpected error")
-			return
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	users, err := api.DB.ListUsersByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	authors := make(map[uuid.UUID]*outputAuthor, len(users))
+	for _, user := range users {
+		authors[user.ID] = &outputAuthor{
+			ID:          user.ID,
+			Handle:      user.Handle,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarUrl,
 		}
-		chirps = userChirps
 	}
+	for i := range chirps {
+		chirps[i].Author = authors[chirps[i].UserID]
+	}
+	return nil
+}
 
+func newChirpPage(chirps []database.Chirp, page pageParams) chirpPage {
+	var nextCursor string
+	if len(chirps) > int(page.Limit) {
+		chirps = chirps[:page.Limit]
+		last := chirps[len(chirps)-1]
+		nextCursor = encodeCursor(pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
 	output := make([]outputChirp, len(chirps))
 	for i, chirp := range chirps {
-		output[i] = outputChirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID.UUID,
+		output[i] = newOutputChirp(chirp)
+	}
+	return chirpPage{Chirps: output, NextCursor: nextCursor}
+}
+
+// getChirps answers with an array of chirps. When there are more, the cursor
+// of the next page is in the X-Next-Cursor header.
+func (api *ApiConfig) getChirps(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParams(r)
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	var authorID uuid.NullUUID
+	if rawAuthorID := r.URL.Query().Get("author_id"); rawAuthorID != "" {
+		parsed, err := uuid.Parse(rawAuthorID)
+		if err != nil {
+			BadRequestResponse(w, "Invalid author_id")
+			return
 		}
+		authorID = uuid.NullUUID{UUID: parsed, Valid: true}
+	}
+
+	if query := r.URL.Query().Get("q"); query != "" {
+		api.searchChirps(w, r, query, authorID, page)
+		return
+	}
+	if page.Sort == sortRelevance {
+		BadRequestResponse(w, "sort=relevance requires a search query")
+		return
 	}
-	if sort == "desc" {
-		slices.Reverse(output)
+
+	cursorCreatedAt, cursorID := page.Keyset()
+	var chirps []database.Chirp
+	if page.Sort == sortDesc {
+		chirps, err = api.DB.ListChirpsDesc(r.Context(), database.ListChirpsDescParams{
+			AuthorID:        authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			PageLimit:       page.FetchLimit(),
+		})
+	} else {
+		chirps, err = api.DB.ListChirpsAsc(r.Context(), database.ListChirpsAscParams{
+			AuthorID:        authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			PageLimit:       page.FetchLimit(),
+		})
+	}
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
 	}
-	OkResponse(w, output)
+	output := newChirpPage(chirps, page)
+	if err := api.hydrateChirps(r.Context(), api.viewerIDFromRequest(r), output.Chirps); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	if output.NextCursor != "" {
+		w.Header().Set(nextCursorHeader, output.NextCursor)
+	}
+	OkResponse(w, output.Chirps)
 }
 
 func (api *ApiConfig) getChirp(w http.ResponseWriter, r *http.Request) {
@@ -176,14 +329,12 @@ func (api *ApiConfig) getChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	output := outputChirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID.UUID,
+	output := []outputChirp{newOutputChirp(chirp)}
+	if err := api.hydrateChirps(r.Context(), api.viewerIDFromRequest(r), output); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
 	}
-	OkResponse(w, output)
+	OkResponse(w, output[0])
 }
 
 func (api *ApiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
@@ -203,13 +354,50 @@ func (api *ApiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	if chirp.UserID.UUID != userID {
+	if chirp.DeletedAt.Valid {
+		NotFoundResponse(w, "Chirp not found")
+		return
+	}
+	moderating := chirp.UserID.UUID != userID
+	if moderating && !auth.HasRole(userRoleFromRequest(r), auth.RoleModerator) {
 		ForbiddenResponse(w, "Chirp does not belong to your user")
 		return
 	}
-	err = api.DB.DeleteChirp(r.Context(), chirpID)
+	if moderating {
+		// The entry is written first so that no moderator deletion goes
+		// unrecorded.
+		err = api.audit(r.Context(), database.CreateAuditLogEntryParams{
+			ActorID:      uuid.NullUUID{UUID: userID, Valid: true},
+			Action:       auditDeleteChirp,
+			TargetUserID: chirp.UserID,
+			ChirpID:      uuid.NullUUID{UUID: chirp.ID, Valid: true},
+			Details:      chirp.Body,
+		})
+		if err != nil {
+			InternalServerErrorResponse(w, "Could not delete chirp. Try again later")
+			return
+		}
+	}
+	// Chirps with replies are kept as tombstones so the thread stays intact.
+	// Their media goes either way.
+	var mediaKeys []database.DeleteChirpMediaRow
+	err = api.inTx(r.Context(), func(queries *database.Queries) error {
+		mediaKeys, err = queries.DeleteChirpMedia(r.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
+		if err != nil {
+			return err
+		}
+		tombstoned, err := queries.TombstoneChirp(r.Context(), chirpID)
+		if err == nil && tombstoned == 0 {
+			err = queries.DeleteChirp(r.Context(), chirpID)
+		}
+		return err
+	})
 	if err != nil {
 		InternalServerErrorResponse(w, "Could not delete chirp. Try again later")
+		return
+	}
+	for _, media := range mediaKeys {
+		api.deleteMediaFiles(r.Context(), media.StorageKey, media.ThumbnailKey)
 	}
 	RespondWithJSON(w, http.StatusNoContent, struct{}{})
 }
@@ -0,0 +1,71 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func chirpRows(userID uuid.UUID, count int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "body", "user_id", "search_vector", "reply_to", "deleted_at"})
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < count; i++ {
+		createdAt := start.Add(time.Duration(i) * time.Minute)
+		rows.AddRow(uuid.New(), createdAt, createdAt, "chirp", userID, nil, nil, nil)
+	}
+	return rows
+}
+
+// expectChirpDetails expects the queries that add engagement, media, authors
+// and mentions to a page of chirps, and finds none of them.
+func expectChirpDetails(s *testServer) {
+	s.expectQuery("GetChirpsEngagement").WillReturnRows(sqlmock.NewRows([]string{"chirp_id"}))
+	s.expectQuery("ListMediaForChirps").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.expectQuery("ListUsersByIDs").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.expectQuery("ListMentionsForChirps").WillReturnRows(sqlmock.NewRows([]string{"chirp_id"}))
+}
+
+func Test_GetChirpsSendsTheNextCursorInAHeader(t *testing.T) {
+	s := newTestServer(t, nil)
+	userID := uuid.New()
+	s.expectQuery("ListChirpsAsc").WillReturnRows(chirpRows(userID, 3))
+	expectChirpDetails(s)
+	w := s.do(http.MethodGet, "/api/chirps?limit=2", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/chirps = %d, expected %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var chirps []map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&chirps); err != nil {
+		t.Fatalf("GET /api/chirps should answer with an array: %s", err)
+	}
+	if len(chirps) != 2 {
+		t.Errorf("Got %d chirps, expected 2", len(chirps))
+	}
+	cursor := w.Header().Get("X-Next-Cursor")
+	if cursor == "" {
+		t.Fatal("X-Next-Cursor should be set when there is another page")
+	}
+
+	s.expectQuery("ListChirpsAsc").WithArgs(nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 3).WillReturnRows(chirpRows(userID, 1))
+	expectChirpDetails(s)
+	w = s.do(http.MethodGet, "/api/chirps?limit=2&cursor="+cursor, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/chirps with a cursor = %d, expected %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if next := w.Header().Get("X-Next-Cursor"); next != "" {
+		t.Errorf("X-Next-Cursor should not be set on the last page, got %q", next)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_GetChirpsRejectsInvalidCursors(t *testing.T) {
+	s := newTestServer(t, nil)
+	if w := s.do(http.MethodGet, "/api/chirps?cursor=not-a-cursor", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /api/chirps with an invalid cursor = %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+	s.checkExpectations(t)
+}
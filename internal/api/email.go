@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/mailer"
+	"github.com/JP-Go/http-server-go/internal/throttle"
+	"github.com/google/uuid"
+)
+
+const (
+	verificationTokenTTL  = 24 * time.Hour
+	passwordResetTokenTTL = time.Hour
+	mailSendTimeout       = 30 * time.Second
+)
+
+// passwordResetPolicy limits reset emails per address, so that the endpoint
+// can not be used to flood an inbox.
+var passwordResetPolicy = throttle.Policy{
+	FreeAttempts:     3,
+	BaseDelay:        5 * time.Minute,
+	MaxDelay:         time.Hour,
+	LockoutThreshold: 10,
+	LockoutDuration:  24 * time.Hour,
+	Window:           24 * time.Hour,
+}
+
+// passwordResetIPPolicy limits reset emails per client address, so that one
+// client can not flood many inboxes either.
+var passwordResetIPPolicy = throttle.Policy{
+	FreeAttempts:     10,
+	BaseDelay:        time.Minute,
+	MaxDelay:         time.Hour,
+	LockoutThreshold: 50,
+	LockoutDuration:  24 * time.Hour,
+	Window:           24 * time.Hour,
+}
+
+type EmailTokenRequestBody struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequestBody struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequestBody struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("Email is not a valid address.")
+	}
+	return nil
+}
+
+// sendMail delivers msg in the background so that neither a slow relay nor
+// the time it takes reveals anything to the client.
+func (cfg *ApiConfig) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := cfg.Mailer.Send(ctx, msg); err != nil {
+			slog.Error("Could not send email", "subject", msg.Subject, "error", err)
+		}
+	}()
+}
+
+func (cfg *ApiConfig) emailLink(path, token string) string {
+	return cfg.BaseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+func (cfg *ApiConfig) sendVerificationEmail(user database.User) error {
+	token, err := cfg.Keys.MakeEmailToken(auth.PurposeVerifyEmail, user.ID, user.Email, user.TokenVersion, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your Chirpy email address",
+		Body: fmt.Sprintf("Hi @%s,\n\nConfirm your email address by opening this link within 24 hours:\n\n%s\n",
+			user.Handle, cfg.emailLink("/app/verify-email/", token)),
+	})
+	return nil
+}
+
+var errEmailTokenUsed = errors.New("Email token was already used")
+
+// consumeEmailToken makes an email token single use. It returns
+// errEmailTokenUsed when the token was already used. Callers consume the
+// token in the transaction that acts on it, so that a failure leaves the link
+// usable.
+func consumeEmailToken(ctx context.Context, queries *database.Queries, token auth.EmailToken) error {
+	consumed, err := queries.ConsumeEmailToken(ctx, database.ConsumeEmailTokenParams{
+		ID:        uuid.MustParse(token.ID),
+		ExpiresAt: token.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	if consumed != 1 {
+		return errEmailTokenUsed
+	}
+	return nil
+}
+
+func (cfg *ApiConfig) deleteExpiredEmailTokens(ctx context.Context) {
+	if err := cfg.DB.DeleteExpiredEmailTokens(ctx); err != nil {
+		slog.Error("Could not delete expired email tokens", "error", err)
+	}
+}
+
+func (cfg *ApiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var body EmailTokenRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	token, err := cfg.Keys.ValidateEmailToken(auth.PurposeVerifyEmail, body.Token)
+	if err != nil {
+		BadRequestResponse(w, "Invalid or expired token.")
+		return
+	}
+	var user database.User
+	err = cfg.inTx(r.Context(), func(queries *database.Queries) error {
+		if err := consumeEmailToken(r.Context(), queries, token); err != nil {
+			return err
+		}
+		user, err = queries.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+			ID:    token.UserID,
+			Email: token.Email,
+		})
+		return err
+	})
+	if err != nil {
+		// sql.ErrNoRows means the user changed their email since the token
+		// was sent.
+		if errors.Is(err, errEmailTokenUsed) || errors.Is(err, sql.ErrNoRows) {
+			BadRequestResponse(w, "Invalid or expired token.")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		}
+		return
+	}
+	cfg.deleteExpiredEmailTokens(r.Context())
+	OkResponse(w, newUser(user))
+}
+
+func (cfg *ApiConfig) resendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		BadRequestResponse(w, "Email already verified.")
+		return
+	}
+	if err := cfg.sendVerificationEmail(user); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// forgotPassword answers the same way whether or not the email belongs to a
+// user, so it can not be used to find out who has an account.
+func (cfg *ApiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var body ForgotPasswordRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	if err := validateEmail(body.Email); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	// Every request counts, whether the email exists or not, so the limit
+	// does not reveal accounts either.
+	if retryAfter, ok := cfg.resetThrottle.reserve(body.Email, clientIP(r)); !ok {
+		TooManyRequestsResponse(w, retryAfter, "Too many password reset requests. Try again later.")
+		return
+	}
+	go cfg.sendPasswordResetEmail(body.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *ApiConfig) sendPasswordResetEmail(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+	defer cancel()
+	user, err := cfg.DB.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("Could not look up user for password reset", "error", err)
+		}
+		return
+	}
+	token, err := cfg.Keys.MakeEmailToken(auth.PurposeResetPassword, user.ID, user.Email, user.TokenVersion, passwordResetTokenTTL)
+	if err != nil {
+		slog.Error("Could not create password reset token", "error", err)
+		return
+	}
+	cfg.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Hi @%s,\n\nSomeone asked to reset your password. If it was you, open this link within an hour:\n\n%s\n\nOtherwise you can ignore this email.\n",
+			user.Handle, cfg.emailLink("/app/reset-password/", token)),
+	})
+}
+
+// resetPassword sets a new password. Like any credentials change it signs the
+// user out everywhere and deletes their personal access tokens.
+func (cfg *ApiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var body ResetPasswordRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	if body.Password == "" {
+		BadRequestResponse(w, "Password must not be empty.")
+		return
+	}
+	token, err := cfg.Keys.ValidateEmailToken(auth.PurposeResetPassword, body.Token)
+	if err != nil {
+		BadRequestResponse(w, "Invalid or expired token.")
+		return
+	}
+	user, err := cfg.DB.GetUserByID(r.Context(), token.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			BadRequestResponse(w, "Invalid or expired token.")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		}
+		return
+	}
+	// The password or the email changed since the token was sent.
+	if user.TokenVersion != token.TokenVersion || user.Email != token.Email {
+		BadRequestResponse(w, "Invalid or expired token.")
+		return
+	}
+	if err := cfg.PasswordPolicy.Check(r.Context(), body.Password, passwordUserInputs(user.Email, user.Handle, user.DisplayName)...); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	hashedPassword, err := cfg.Passwords.Hash(body.Password)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not reset password. Try again later.")
+		return
+	}
+	// The token is only spent when the password changes and every session
+	// and personal access token is gone, so a failure can be retried.
+	err = cfg.inTx(r.Context(), func(queries *database.Queries) error {
+		if err := consumeEmailToken(r.Context(), queries, token); err != nil {
+			return err
+		}
+		user, err = queries.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             user.ID,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			return err
+		}
+		if err := queries.RevokeAllSessions(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return queries.DeleteUserPersonalAccessTokens(r.Context(), user.ID)
+	})
+	if err != nil {
+		if errors.Is(err, errEmailTokenUsed) {
+			BadRequestResponse(w, "Invalid or expired token.")
+		} else {
+			InternalServerErrorResponse(w, "Could not reset password. Try again later.")
+		}
+		return
+	}
+	cfg.tokenVersions.set(user.ID, user.TokenVersion)
+	cfg.deleteExpiredEmailTokens(r.Context())
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package api_test
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/JP-Go/http-server-go/internal/mailer"
+)
+
+var emailLinkPattern = regexp.MustCompile(regexp.QuoteMeta(testBaseURL) + `\S+`)
+
+// followEmailLink opens the link of msg like a browser would and returns the
+// token it carries once the page it leads to is checked to post it to
+// endpoint.
+func followEmailLink(t *testing.T, s *testServer, msg mailer.Message, endpoint string) string {
+	t.Helper()
+	link := emailLinkPattern.FindString(msg.Body)
+	if link == "" {
+		t.Fatalf("Email has no link: %q", msg.Body)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("Email link %q is not a URL: %s", link, err)
+	}
+	page := s.do(http.MethodGet, parsed.RequestURI(), "", nil)
+	if page.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, expected %d", parsed.RequestURI(), page.Code, http.StatusOK)
+	}
+	if !strings.Contains(page.Body.String(), `fetch("`+endpoint+`"`) {
+		t.Fatalf("Page at %s does not post to %s", parsed.RequestURI(), endpoint)
+	}
+	token := parsed.Query().Get("token")
+	if token == "" {
+		t.Fatalf("Email link %q has no token", link)
+	}
+	return token
+}
+
+func Test_VerificationEmailLinkVerifiesTheEmail(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	s.expectQuery("CreateUser").WillReturnRows(userRow(user))
+
+	w := s.do(http.MethodPost, "/api/users", "", map[string]string{
+		"email":    user.Email,
+		"password": "tunnel-cactus-violin-58",
+		"handle":   user.Handle,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/users = %d, expected %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	token := followEmailLink(t, s, s.mailer.receive(t), "/api/users/verify")
+
+	verified := user
+	verified.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	s.db.ExpectBegin()
+	s.expectExec("ConsumeEmailToken").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectQuery("VerifyUserEmail").WithArgs(user.ID, user.Email).WillReturnRows(userRow(verified))
+	s.db.ExpectCommit()
+	s.expectExec("DeleteExpiredEmailTokens").WillReturnResult(sqlmock.NewResult(0, 0))
+	w = s.do(http.MethodPost, "/api/users/verify", "", map[string]string{"token": token})
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/users/verify = %d, expected %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// The link is single use.
+	s.db.ExpectBegin()
+	s.expectExec("ConsumeEmailToken").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.db.ExpectRollback()
+	w = s.do(http.MethodPost, "/api/users/verify", "", map[string]string{"token": token})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Reusing the link = %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_PasswordResetEmailLinkResetsThePassword(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	s.expectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(userRow(user))
+
+	w := s.do(http.MethodPost, "/api/password/forgot", "", map[string]string{"email": user.Email})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /api/password/forgot = %d, expected %d", w.Code, http.StatusAccepted)
+	}
+	token := followEmailLink(t, s, s.mailer.receive(t), "/api/password/reset")
+
+	updated := user
+	updated.TokenVersion++
+	s.expectQuery("GetUserByID").WithArgs(user.ID).WillReturnRows(userRow(user))
+	s.db.ExpectBegin()
+	s.expectExec("ConsumeEmailToken").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectQuery("UpdateUserPassword").WillReturnRows(userRow(updated))
+	s.expectExec("RevokeAllSessions").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 2))
+	s.expectExec("DeleteUserPersonalAccessTokens").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.db.ExpectCommit()
+	s.expectExec("DeleteExpiredEmailTokens").WillReturnResult(sqlmock.NewResult(0, 0))
+	w = s.do(http.MethodPost, "/api/password/reset", "", map[string]string{
+		"token":    token,
+		"password": "tunnel-cactus-violin-58",
+	})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("POST /api/password/reset = %d, expected %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_PasswordResetKeepsTheLinkWhenTheResetFails(t *testing.T) {
+	s := newTestServer(t, nil)
+	user := newTestUser()
+	s.expectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(userRow(user))
+	s.do(http.MethodPost, "/api/password/forgot", "", map[string]string{"email": user.Email})
+	token := followEmailLink(t, s, s.mailer.receive(t), "/api/password/reset")
+
+	s.expectQuery("GetUserByID").WithArgs(user.ID).WillReturnRows(userRow(user))
+	s.db.ExpectBegin()
+	s.expectExec("ConsumeEmailToken").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectQuery("UpdateUserPassword").WillReturnRows(userRow(user))
+	s.expectExec("RevokeAllSessions").WillReturnError(sql.ErrConnDone)
+	s.db.ExpectRollback()
+	w := s.do(http.MethodPost, "/api/password/reset", "", map[string]string{
+		"token":    token,
+		"password": "tunnel-cactus-violin-58",
+	})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("POST /api/password/reset = %d, expected %d", w.Code, http.StatusInternalServerError)
+	}
+	s.checkExpectations(t)
+}
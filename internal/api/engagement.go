@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// loadEngagement fills the like and repost counters of a batch of chirps with
+// a single query.
+func (api *ApiConfig) loadEngagement(ctx context.Context, viewerID uuid.NullUUID, chirps []outputChirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID
+	}
+	rows, err := api.DB.GetChirpsEngagement(ctx, database.GetChirpsEngagementParams{
+		ViewerID: viewerID,
+		ChirpIds: ids,
+	})
+	if err != nil {
+		return err
+	}
+	byChirp := make(map[uuid.UUID]database.GetChirpsEngagementRow, len(rows))
+	for _, row := range rows {
+		byChirp[row.ChirpID] = row
+	}
+	for i := range chirps {
+		row := byChirp[chirps[i].ID]
+		chirps[i].LikeCount = row.LikeCount
+		chirps[i].RepostCount = row.RepostCount
+		chirps[i].ReplyCount = row.ReplyCount
+		chirps[i].LikedByMe = row.LikedByMe
+	}
+	return nil
+}
+
+// findPathChirp resolves the {chirpID} path value to an existing chirp,
+// writing the error response itself when it cannot.
+func (api *ApiConfig) findPathChirp(w http.ResponseWriter, r *http.Request) (database.Chirp, bool) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid chirpID")
+		return database.Chirp{}, false
+	}
+	chirp, err := api.DB.FindChirpByID(r.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			NotFoundResponse(w, "Chirp not found")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error")
+		}
+		return database.Chirp{}, false
+	}
+	if chirp.DeletedAt.Valid {
+		NotFoundResponse(w, "Chirp not found")
+		return database.Chirp{}, false
+	}
+	return chirp, true
+}
+
+func (api *ApiConfig) likeChirp(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	chirp, ok := api.findPathChirp(w, r)
+	if !ok {
+		return
+	}
+	err := api.DB.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirp.ID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not like chirp. Try again later")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ApiConfig) unlikeChirp(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid chirpID")
+		return
+	}
+	err = api.DB.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not unlike chirp. Try again later")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ApiConfig) repostChirp(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	chirp, ok := api.findPathChirp(w, r)
+	if !ok {
+		return
+	}
+	err := api.DB.RepostChirp(r.Context(), database.RepostChirpParams{
+		UserID:  userID,
+		ChirpID: chirp.ID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not repost chirp. Try again later")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ApiConfig) undoRepostChirp(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid chirpID")
+		return
+	}
+	err = api.DB.UndoRepostChirp(r.Context(), database.UndoRepostChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not undo repost. Try again later")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JP-Go/http-server-go/internal/chirptext"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const defaultTrendingHours = 24
+const maxTrendingHours = 24 * 7
+const defaultTrendingLimit = 10
+const maxTrendingLimit = 50
+
+type hashtagEntity struct {
+	Tag     string `json:"tag"`
+	Indices [2]int `json:"indices"`
+}
+
+type mentionEntity struct {
+	Handle  string     `json:"handle"`
+	UserID  *uuid.UUID `json:"user_id"`
+	Indices [2]int     `json:"indices"`
+}
+
+type outputEntities struct {
+	Hashtags []hashtagEntity `json:"hashtags"`
+	Mentions []mentionEntity `json:"mentions"`
+}
+
+type trendingHashtag struct {
+	Tag        string `json:"tag"`
+	ChirpCount int64  `json:"chirp_count"`
+}
+
+// saveEntities indexes the hashtags and mentions of a chirp body, replacing
+// whatever was indexed for a previous version of it. It runs on queries so
+// that it can share the transaction that wrote the body.
+func saveEntities(ctx context.Context, queries *database.Queries, chirpID uuid.UUID, body string) error {
+	if err := queries.ClearChirpEntities(ctx, chirpID); err != nil {
+		return err
+	}
+	entities := chirptext.Extract(body)
+	if tags := chirptext.Unique(entities.Hashtags); len(tags) > 0 {
+		err := queries.AddChirpHashtags(ctx, database.AddChirpHashtagsParams{
+			ChirpID: chirpID,
+			Tags:    tags,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if handles := chirptext.Unique(entities.Mentions); len(handles) > 0 {
+		err := queries.AddChirpMentions(ctx, database.AddChirpMentionsParams{
+			ChirpID: chirpID,
+			Handles: handles,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEntities parses the entities of every chirp and resolves its mentions to
+// the users they were stored for.
+func (api *ApiConfig) loadEntities(ctx context.Context, chirps []outputChirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID
+	}
+	rows, err := api.DB.ListMentionsForChirps(ctx, ids)
+	if err != nil {
+		return err
+	}
+	mentioned := make(map[uuid.UUID]map[string]uuid.UUID)
+	for _, row := range rows {
+		if mentioned[row.ChirpID] == nil {
+			mentioned[row.ChirpID] = make(map[string]uuid.UUID)
+		}
+		mentioned[row.ChirpID][strings.ToLower(row.Handle)] = row.UserID
+	}
+
+	for i := range chirps {
+		entities := chirptext.Extract(chirps[i].Body)
+		output := outputEntities{
+			Hashtags: make([]hashtagEntity, len(entities.Hashtags)),
+			Mentions: make([]mentionEntity, len(entities.Mentions)),
+		}
+		for j, hashtag := range entities.Hashtags {
+			output.Hashtags[j] = hashtagEntity{Tag: hashtag.Text, Indices: [2]int{hashtag.Start, hashtag.End}}
+		}
+		for j, mention := range entities.Mentions {
+			output.Mentions[j] = mentionEntity{Handle: mention.Text, Indices: [2]int{mention.Start, mention.End}}
+			if userID, ok := mentioned[chirps[i].ID][mention.Text]; ok {
+				output.Mentions[j].UserID = &userID
+			}
+		}
+		chirps[i].Entities = output
+	}
+	return nil
+}
+
+func (api *ApiConfig) getHashtagChirps(w http.ResponseWriter, r *http.Request) {
+	page, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	tag := strings.ToLower(strings.TrimPrefix(r.PathValue("tag"), "#"))
+	if tag == "" {
+		BadRequestResponse(w, "Invalid tag")
+		return
+	}
+	cursorCreatedAt, cursorID := page.Keyset()
+	chirps, err := api.DB.ListHashtagChirps(r.Context(), database.ListHashtagChirpsParams{
+		Tag:             tag,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       page.FetchLimit(),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	output := newChirpPage(chirps, page)
+	if err := api.hydrateChirps(r.Context(), api.viewerIDFromRequest(r), output.Chirps); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	OkResponse(w, output)
+}
+
+// getTrendingHashtags ranks hashtags by how many chirps used them in the last
+// ?hours= hours.
+func (api *ApiConfig) getTrendingHashtags(w http.ResponseWriter, r *http.Request) {
+	hours, err := parseBoundedInt(r.URL.Query().Get("hours"), defaultTrendingHours, maxTrendingHours)
+	if err != nil {
+		BadRequestResponse(w, "hours must be a number between 1 and "+strconv.Itoa(maxTrendingHours))
+		return
+	}
+	limit, err := parseBoundedInt(r.URL.Query().Get("limit"), defaultTrendingLimit, maxTrendingLimit)
+	if err != nil {
+		BadRequestResponse(w, "limit must be a number between 1 and "+strconv.Itoa(maxTrendingLimit))
+		return
+	}
+	rows, err := api.DB.TrendingHashtags(r.Context(), database.TrendingHashtagsParams{
+		Hours:    int32(hours),
+		TagLimit: int32(limit),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	output := make([]trendingHashtag, len(rows))
+	for i, row := range rows {
+		output[i] = trendingHashtag{Tag: row.Tag, ChirpCount: row.ChirpCount}
+	}
+	OkResponse(w, output)
+}
+
+func (api *ApiConfig) getMyMentions(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	page, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	cursorCreatedAt, cursorID := page.Keyset()
+	chirps, err := api.DB.ListMentionChirps(r.Context(), database.ListMentionChirpsParams{
+		UserID:          userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       page.FetchLimit(),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	output := newChirpPage(chirps, page)
+	if err := api.hydrateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, output.Chirps); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	OkResponse(w, output)
+}
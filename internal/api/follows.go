@@ -0,0 +1,179 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+type outputFollow struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type followPage struct {
+	Users      []outputFollow `json:"users"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func newFollowPage(rows []database.ListFollowersRow, page pageParams) followPage {
+	var nextCursor string
+	if len(rows) > int(page.Limit) {
+		rows = rows[:page.Limit]
+		last := rows[len(rows)-1]
+		nextCursor = encodeCursor(pageCursor{CreatedAt: last.CreatedAt, ID: last.UserID})
+	}
+	output := make([]outputFollow, len(rows))
+	for i, row := range rows {
+		output[i] = outputFollow{UserID: row.UserID, FollowedAt: row.CreatedAt}
+	}
+	return followPage{Users: output, NextCursor: nextCursor}
+}
+
+// findPathUser resolves the {userID} path value to an existing user, writing
+// the error response itself when it cannot. Accounts pending deletion are
+// treated as gone.
+func (api *ApiConfig) findPathUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid userID")
+		return database.User{}, false
+	}
+	user, err := api.DB.GetUserByID(r.Context(), userID)
+	if err == nil && user.DeletedAt.Valid {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			NotFoundResponse(w, "User not found")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error")
+		}
+		return database.User{}, false
+	}
+	return user, true
+}
+
+func (api *ApiConfig) followUser(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	followee, ok := api.findPathUser(w, r)
+	if !ok {
+		return
+	}
+	if followee.ID == userID {
+		BadRequestResponse(w, "You can not follow yourself")
+		return
+	}
+	err := api.DB.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followee.ID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not follow user. Try again later")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ApiConfig) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid userID")
+		return
+	}
+	err = api.DB.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not unfollow user. Try again later")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ApiConfig) getFollowers(w http.ResponseWriter, r *http.Request) {
+	page, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	user, ok := api.findPathUser(w, r)
+	if !ok {
+		return
+	}
+	cursorCreatedAt, cursorID := page.Keyset()
+	rows, err := api.DB.ListFollowers(r.Context(), database.ListFollowersParams{
+		UserID:          user.ID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       page.FetchLimit(),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	OkResponse(w, newFollowPage(rows, page))
+}
+
+func (api *ApiConfig) getFollowing(w http.ResponseWriter, r *http.Request) {
+	page, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	user, ok := api.findPathUser(w, r)
+	if !ok {
+		return
+	}
+	cursorCreatedAt, cursorID := page.Keyset()
+	rows, err := api.DB.ListFollowing(r.Context(), database.ListFollowingParams{
+		UserID:          user.ID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       page.FetchLimit(),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	followers := make([]database.ListFollowersRow, len(rows))
+	for i, row := range rows {
+		followers[i] = database.ListFollowersRow(row)
+	}
+	OkResponse(w, newFollowPage(followers, page))
+}
+
+// getTimeline returns the chirps of every account the caller follows, newest
+// first.
+func (api *ApiConfig) getTimeline(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	page, err := parseNewestFirstPageParams(r)
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	cursorCreatedAt, cursorID := page.Keyset()
+	chirps, err := api.DB.ListTimeline(r.Context(), database.ListTimelineParams{
+		FollowerID:      userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		PageLimit:       page.FetchLimit(),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	output := newChirpPage(chirps, page)
+	viewerID := uuid.NullUUID{UUID: userID, Valid: true}
+	if err := api.hydrateChirps(r.Context(), viewerID, output.Chirps); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	OkResponse(w, output)
+}
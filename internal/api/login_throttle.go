@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/throttle"
+)
+
+// DefaultAccountLoginPolicy throttles wrong passwords for one email address.
+var DefaultAccountLoginPolicy = throttle.Policy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  15 * time.Minute,
+	Window:           time.Hour,
+}
+
+// DefaultIPLoginPolicy throttles wrong passwords from one address. It is more
+// lenient since many users can share an address behind a NAT.
+var DefaultIPLoginPolicy = throttle.Policy{
+	FreeAttempts:     20,
+	BaseDelay:        time.Second,
+	MaxDelay:         time.Minute,
+	LockoutThreshold: 100,
+	LockoutDuration:  time.Hour,
+	Window:           time.Hour,
+}
+
+// loginThrottle tracks failed logins per account and per client address.
+// Unknown emails are tracked like existing ones so that lockouts do not reveal
+// which accounts exist.
+type loginThrottle struct {
+	accounts *throttle.Limiter
+	ips      *throttle.Limiter
+}
+
+func newLoginThrottle(accountPolicy, ipPolicy throttle.Policy) *loginThrottle {
+	return &loginThrottle{
+		accounts: throttle.NewLimiter(accountPolicy),
+		ips:      throttle.NewLimiter(ipPolicy),
+	}
+}
+
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// reserve counts an attempt against the account and the client address
+// before the password is checked, and returns how long the client has to
+// wait when it may not try. Counting first keeps parallel requests from all
+// passing the check before any failure is recorded. A wrong password keeps
+// the attempt counted.
+func (t *loginThrottle) reserve(email, ip string) (time.Duration, bool) {
+	now := time.Now()
+	accountWait, ok := t.accounts.Reserve(accountKey(email), now)
+	if !ok {
+		return accountWait, false
+	}
+	ipWait, ok := t.ips.Reserve(ip, now)
+	if !ok {
+		t.accounts.Refund(accountKey(email))
+		return ipWait, false
+	}
+	return 0, true
+}
+
+// refundIP gives the attempt back to the client address once the password
+// proved right.
+func (t *loginThrottle) refundIP(ip string) {
+	t.ips.Refund(ip)
+}
+
+// succeed forgets the failures of the account. It must only be called once
+// the user fully authenticated, second factor included.
+func (t *loginThrottle) succeed(email string) {
+	t.accounts.Reset(accountKey(email))
+}
+
+func TooManyRequestsResponse(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+	RespondWithError(w, http.StatusTooManyRequests, msg)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/media"
+	"github.com/google/uuid"
+)
+
+const maxMediaPerChirp = 4
+
+// unattachedMediaTTL is how long an upload can wait to be attached to a chirp
+// before it is deleted.
+const unattachedMediaTTL = 24 * time.Hour
+const mediaSweepInterval = time.Hour
+
+type outputMedia struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ContentType  string    `json:"content_type"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+}
+
+func (api *ApiConfig) newOutputMedia(medium database.Medium) outputMedia {
+	return outputMedia{
+		ID:           medium.ID,
+		CreatedAt:    medium.CreatedAt,
+		ContentType:  medium.ContentType,
+		URL:          api.Media.URL(medium.StorageKey),
+		ThumbnailURL: api.Media.URL(medium.ThumbnailKey),
+		Width:        medium.Width,
+		Height:       medium.Height,
+	}
+}
+
+// uploadMedia stores an image sent as the "file" field of a multipart form.
+// The returned id can then be attached to a chirp through media_ids. Uploads
+// still unattached after unattachedMediaTTL are deleted.
+func (api *ApiConfig) uploadMedia(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	r.Body = http.MaxBytesReader(w, r.Body, media.MaxUploadSize+1<<20)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			RespondWithError(w, http.StatusRequestEntityTooLarge, "File too large")
+		} else {
+			BadRequestResponse(w, "Missing file field in multipart form")
+		}
+		return
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, media.MaxUploadSize+1))
+	if err != nil {
+		BadRequestResponse(w, "Could not read file")
+		return
+	}
+	if len(content) > media.MaxUploadSize {
+		RespondWithError(w, http.StatusRequestEntityTooLarge, "File too large")
+		return
+	}
+	image, err := media.ProcessImage(content)
+	if err != nil {
+		if errors.Is(err, media.ErrUnsupportedType) {
+			RespondWithError(w, http.StatusUnsupportedMediaType, err.Error())
+		} else {
+			BadRequestResponse(w, err.Error())
+		}
+		return
+	}
+
+	mediaID := uuid.New()
+	storageKey := mediaID.String() + image.Extension
+	thumbnailKey := mediaID.String() + "_thumb" + image.Extension
+	if err := api.Media.Put(r.Context(), storageKey, bytes.NewReader(content)); err != nil {
+		InternalServerErrorResponse(w, "Could not store file. Try again later")
+		return
+	}
+	if err := api.Media.Put(r.Context(), thumbnailKey, bytes.NewReader(image.Thumbnail)); err != nil {
+		api.Media.Delete(r.Context(), storageKey)
+		InternalServerErrorResponse(w, "Could not store file. Try again later")
+		return
+	}
+	medium, err := api.DB.CreateMedia(r.Context(), database.CreateMediaParams{
+		ID:           mediaID,
+		UserID:       userID,
+		ContentType:  image.ContentType,
+		StorageKey:   storageKey,
+		ThumbnailKey: thumbnailKey,
+		SizeBytes:    int64(len(content)),
+		Width:        int32(image.Width),
+		Height:       int32(image.Height),
+	})
+	if err != nil {
+		api.Media.Delete(r.Context(), storageKey)
+		api.Media.Delete(r.Context(), thumbnailKey)
+		InternalServerErrorResponse(w, "Could not store file. Try again later")
+		return
+	}
+	RespondWithJSON(w, http.StatusCreated, api.newOutputMedia(medium))
+}
+
+// validateMediaIDs checks that every id is an upload of the user that is not
+// attached to a chirp yet.
+func (api *ApiConfig) validateMediaIDs(ctx context.Context, userID uuid.UUID, mediaIDs []uuid.UUID) error {
+	if len(mediaIDs) > maxMediaPerChirp {
+		return errors.New("A chirp can have at most 4 media attachments")
+	}
+	seen := make(map[uuid.UUID]bool, len(mediaIDs))
+	for _, id := range mediaIDs {
+		if seen[id] {
+			return errors.New("Duplicated media id")
+		}
+		seen[id] = true
+	}
+	if len(mediaIDs) == 0 {
+		return nil
+	}
+	count, err := api.DB.CountAttachableMedia(ctx, database.CountAttachableMediaParams{
+		Ids:    mediaIDs,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+	if count != int64(len(mediaIDs)) {
+		return errors.New("Unknown or already used media id")
+	}
+	return nil
+}
+
+var errMediaTaken = errors.New("Media was attached to another chirp")
+
+func attachMedia(ctx context.Context, queries *database.Queries, userID, chirpID uuid.UUID, mediaIDs []uuid.UUID) error {
+	for position, id := range mediaIDs {
+		attached, err := queries.AttachMedia(ctx, database.AttachMediaParams{
+			ChirpID:  uuid.NullUUID{UUID: chirpID, Valid: true},
+			Position: int32(position),
+			ID:       id,
+			UserID:   userID,
+		})
+		if err != nil {
+			return err
+		}
+		if attached == 0 {
+			return errMediaTaken
+		}
+	}
+	return nil
+}
+
+// deleteMediaFiles removes stored files whose rows are gone. Failures are only
+// logged: the rows no longer point to the files.
+func (api *ApiConfig) deleteMediaFiles(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := api.Media.Delete(ctx, key); err != nil {
+			slog.Error("Could not delete media file", "key", key, "error", err)
+		}
+	}
+}
+
+// sweepUnattachedMedia deletes uploads that were never attached to a chirp,
+// rows and files alike.
+func (api *ApiConfig) sweepUnattachedMedia(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		uploadedBefore := time.Now().UTC().Add(-unattachedMediaTTL)
+		deleted, err := api.DB.DeleteUnattachedMedia(ctx, uploadedBefore)
+		if err != nil {
+			slog.Error("Could not delete unattached media", "error", err)
+		}
+		for _, medium := range deleted {
+			api.deleteMediaFiles(ctx, medium.StorageKey, medium.ThumbnailKey)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (api *ApiConfig) loadMedia(ctx context.Context, chirps []outputChirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(chirps))
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID
+	}
+	rows, err := api.DB.ListMediaForChirps(ctx, ids)
+	if err != nil {
+		return err
+	}
+	byChirp := make(map[uuid.UUID][]outputMedia)
+	for _, medium := range rows {
+		byChirp[medium.ChirpID.UUID] = append(byChirp[medium.ChirpID.UUID], api.newOutputMedia(medium))
+	}
+	for i := range chirps {
+		chirps[i].Media = byChirp[chirps[i].ID]
+		if chirps[i].Media == nil {
+			chirps[i].Media = []outputMedia{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/totp"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+)
+
+type MFAChallengeResponseBody struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type MFALoginRequestBody struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPCodeRequestBody struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type outputTOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type outputRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (cfg *ApiConfig) totpEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	userTOTP, err := cfg.DB.GetUserTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return userTOTP.EnabledAt.Valid, nil
+}
+
+func (cfg *ApiConfig) sendMFAChallenge(w http.ResponseWriter, userID uuid.UUID) {
+	challenge, err := cfg.Keys.MakeMFAChallenge(userID, mfaChallengeTTL)
+	if err != nil {
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	OkResponse(w, MFAChallengeResponseBody{MFARequired: true, MFAToken: challenge})
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+// Both are consumed on success so they can not be replayed.
+func verifySecondFactor(ctx context.Context, queries *database.Queries, userTOTP database.UserTotp, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		used, err := queries.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   userTOTP.UserID,
+			CodeHash: totp.HashRecoveryCode(recoveryCode),
+		})
+		return used == 1, err
+	}
+	step, ok := totp.Validate(userTOTP.Secret, code, time.Now(), userTOTP.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+	used, err := queries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		UserID:       userTOTP.UserID,
+		LastUsedStep: step,
+	})
+	return used == 1, err
+}
+
+// loginMFA completes a login that was answered with an MFA challenge.
+func (cfg *ApiConfig) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var body MFALoginRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	if body.Code == "" && body.RecoveryCode == "" {
+		BadRequestResponse(w, "Code must not be empty.")
+		return
+	}
+	challenge, err := cfg.Keys.ValidateMFAChallenge(body.MFAToken)
+	if err != nil {
+		UnauthorizedResponse(w, "Invalid or expired MFA token.")
+		return
+	}
+	dbUser, err := cfg.DB.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	// Wrong codes count against the account like wrong passwords, so asking
+	// for new challenges does not buy more guesses.
+	ip := clientIP(r)
+	if retryAfter, ok := cfg.loginThrottle.reserve(dbUser.Email, ip); !ok {
+		TooManyRequestsResponse(w, retryAfter, "Too many failed login attempts. Try again later.")
+		return
+	}
+	userTOTP, err := cfg.DB.GetUserTOTP(r.Context(), challenge.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	if err != nil || !userTOTP.EnabledAt.Valid {
+		UnauthorizedResponse(w, "Invalid or expired MFA token.")
+		return
+	}
+	ok, err := verifySecondFactor(r.Context(), cfg.DB, userTOTP, body.Code, body.RecoveryCode)
+	if err != nil {
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	if !ok {
+		UnauthorizedResponse(w, "Invalid code.")
+		return
+	}
+	cfg.loginThrottle.refundIP(ip)
+	cfg.loginThrottle.succeed(dbUser.Email)
+	cfg.startSession(w, r, dbUser)
+}
+
+// reserveCodeAttempt counts a code check of a logged in user against their
+// account, so that a stolen access token can not guess codes either. It
+// returns the email the attempt is counted under.
+func (cfg *ApiConfig) reserveCodeAttempt(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (string, bool) {
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return "", false
+	}
+	if retryAfter, ok := cfg.loginThrottle.reserve(user.Email, clientIP(r)); !ok {
+		TooManyRequestsResponse(w, retryAfter, "Too many wrong codes. Try again later.")
+		return "", false
+	}
+	return user.Email, true
+}
+
+// startTOTPEnrollment creates a new secret. Two-factor authentication stays
+// off until a code generated from it is verified.
+func (cfg *ApiConfig) startTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return
+	}
+	_, err = cfg.DB.StartTOTPEnrollment(r.Context(), database.StartTOTPEnrollmentParams{
+		UserID: userID,
+		Secret: secret,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			BadRequestResponse(w, "Two-factor authentication is already enabled.")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		}
+		return
+	}
+	RespondWithJSON(w, http.StatusCreated, outputTOTPEnrollment{
+		Secret:     secret,
+		OtpauthURI: totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+var (
+	errTOTPAlreadyEnabled  = errors.New("Two-factor authentication is already enabled")
+	errInvalidSecondFactor = errors.New("Invalid code")
+)
+
+// verifyTOTPEnrollment turns two-factor authentication on and returns the
+// recovery codes. They are only ever shown here.
+func (cfg *ApiConfig) verifyTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	var body TOTPCodeRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	email, ok := cfg.reserveCodeAttempt(w, r, userID)
+	if !ok {
+		return
+	}
+	userTOTP, err := cfg.DB.GetUserTOTP(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			BadRequestResponse(w, "Start the enrollment first.")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		}
+		return
+	}
+	if userTOTP.EnabledAt.Valid {
+		BadRequestResponse(w, "Two-factor authentication is already enabled.")
+		return
+	}
+	step, ok := totp.Validate(userTOTP.Secret, body.Code, time.Now(), userTOTP.LastUsedStep)
+	if !ok {
+		BadRequestResponse(w, "Invalid code.")
+		return
+	}
+	cfg.loginThrottle.refundIP(clientIP(r))
+	cfg.loginThrottle.succeed(email)
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+	// Two-factor authentication is only on once its recovery codes are saved,
+	// so a failure can be retried with a new code.
+	err = cfg.inTx(r.Context(), func(queries *database.Queries) error {
+		enabled, err := queries.EnableTOTP(r.Context(), database.EnableTOTPParams{
+			UserID:       userID,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			return err
+		}
+		if enabled == 0 {
+			return errTOTPAlreadyEnabled
+		}
+		if err := queries.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+			return err
+		}
+		return queries.CreateRecoveryCodes(r.Context(), database.CreateRecoveryCodesParams{
+			UserID:     userID,
+			CodeHashes: hashes,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, errTOTPAlreadyEnabled) {
+			BadRequestResponse(w, "Two-factor authentication is already enabled.")
+		} else {
+			InternalServerErrorResponse(w, "Could not enable two-factor authentication. Try again later.")
+		}
+		return
+	}
+	OkResponse(w, outputRecoveryCodes{RecoveryCodes: codes})
+}
+
+// disableTOTP needs a current code or a recovery code, so a stolen access
+// token alone can not turn the second factor off.
+func (cfg *ApiConfig) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	var body TOTPCodeRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	email, ok := cfg.reserveCodeAttempt(w, r, userID)
+	if !ok {
+		return
+	}
+	userTOTP, err := cfg.DB.GetUserTOTP(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			NotFoundResponse(w, "Two-factor authentication is not enabled.")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		}
+		return
+	}
+	// A recovery code is only spent if the second factor is really removed.
+	err = cfg.inTx(r.Context(), func(queries *database.Queries) error {
+		if userTOTP.EnabledAt.Valid {
+			ok, err := verifySecondFactor(r.Context(), queries, userTOTP, body.Code, body.RecoveryCode)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errInvalidSecondFactor
+			}
+		}
+		if err := queries.DeleteTOTP(r.Context(), userID); err != nil {
+			return err
+		}
+		return queries.DeleteRecoveryCodes(r.Context(), userID)
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			ForbiddenResponse(w, "Invalid code.")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		}
+		return
+	}
+	cfg.loginThrottle.refundIP(clientIP(r))
+	cfg.loginThrottle.succeed(email)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,12 +2,18 @@ package api
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"net/http"
+	"slices"
 
 	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/google/uuid"
 )
 
 const userIDKey = "user.id"
+const tokenScopesKey = "token.scopes"
+const userRoleKey = "user.role"
 
 func (api *ApiConfig) loggedInMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -16,13 +22,140 @@ func (api *ApiConfig) loggedInMiddleware(next http.Handler) http.Handler {
 			UnauthorizedResponse(w, "No credentials provided")
 			return
 		}
-		userID, err := auth.ValidateJWT(token, api.JwtSecret)
+		if auth.IsPersonalToken(token) {
+			personalToken, err := api.authenticatePersonalToken(r.Context(), token)
+			if err != nil {
+				if errors.Is(err, errInvalidPersonalToken) {
+					UnauthorizedResponse(w, "Unauthorized")
+				} else {
+					InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+				}
+				return
+			}
+			if !allowsPersonalTokens(next, r) {
+				ForbiddenResponse(w, "Personal access tokens cannot be used here.")
+				return
+			}
+			ctx := context.WithValue(r.Context(), userIDKey, personalToken.UserID.String())
+			ctx = context.WithValue(ctx, tokenScopesKey, personalToken.Scopes)
+			// Personal access tokens never carry the privileges of a role.
+			ctx = context.WithValue(ctx, userRoleKey, auth.RoleUser)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		accessToken, err := api.Keys.ParseAccessToken(token)
 		if err != nil {
 			UnauthorizedResponse(w, "Unauthorized")
 			return
 		}
-		ctx := context.WithValue(r.Context(), userIDKey, userID.String())
+		revoked, err := api.accessTokenRevoked(r.Context(), accessToken)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				UnauthorizedResponse(w, "Unauthorized")
+			} else {
+				InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+			}
+			return
+		}
+		if revoked {
+			UnauthorizedResponse(w, "Session expired")
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, accessToken.UserID.String())
+		ctx = context.WithValue(ctx, userRoleKey, accessToken.Role)
 		req := r.WithContext(ctx)
 		next.ServeHTTP(w, req)
 	})
 }
+
+// viewerIDFromRequest identifies the caller on public endpoints. Anonymous
+// requests and requests with an invalid token are treated the same way.
+func (api *ApiConfig) viewerIDFromRequest(r *http.Request) uuid.NullUUID {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	if auth.IsPersonalToken(token) {
+		personalToken, err := api.authenticatePersonalToken(r.Context(), token)
+		if err != nil {
+			return uuid.NullUUID{}
+		}
+		return uuid.NullUUID{UUID: personalToken.UserID, Valid: true}
+	}
+	accessToken, err := api.Keys.ParseAccessToken(token)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	if revoked, err := api.accessTokenRevoked(r.Context(), accessToken); err != nil || revoked {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: accessToken.UserID, Valid: true}
+}
+
+// personalTokenScopes returns the scopes of the personal access token that
+// authenticated the request. ok is false for requests made with a login.
+func personalTokenScopes(r *http.Request) (scopes []string, ok bool) {
+	scopes, ok = r.Context().Value(tokenScopesKey).([]string)
+	return scopes, ok
+}
+
+// tokenGuard is a handler that checks personal access tokens itself. Routes
+// are closed to personal access tokens unless they are wrapped in one, so a
+// route registered without requireScope or requireLogin is not open to every
+// token.
+type tokenGuard http.HandlerFunc
+
+func (g tokenGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g(w, r)
+}
+
+// allowsPersonalTokens reports whether the handler that serves r checks
+// personal access tokens.
+func allowsPersonalTokens(h http.Handler, r *http.Request) bool {
+	if mux, ok := h.(*http.ServeMux); ok {
+		h, _ = mux.Handler(r)
+	}
+	_, ok := h.(tokenGuard)
+	return ok
+}
+
+// requireScope only lets personal access tokens with scope through. Logins
+// are not limited by scopes.
+func requireScope(scope string, next http.Handler) http.Handler {
+	return tokenGuard(func(w http.ResponseWriter, r *http.Request) {
+		if scopes, ok := personalTokenScopes(r); ok && !slices.Contains(scopes, scope) {
+			ForbiddenResponse(w, "This token lacks the "+scope+" scope.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireLogin keeps personal access tokens out of routes that manage the
+// account itself.
+func requireLogin(next http.Handler) http.Handler {
+	return tokenGuard(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := personalTokenScopes(r); ok {
+			ForbiddenResponse(w, "Personal access tokens cannot be used here. Log in instead.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func userRoleFromRequest(r *http.Request) string {
+	role, _ := r.Context().Value(userRoleKey).(string)
+	return role
+}
+
+// requireRole only lets users with at least the given role through. It must
+// run after loggedInMiddleware.
+func requireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.HasRole(userRoleFromRequest(r), role) {
+			ForbiddenResponse(w, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/oidc"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// oidcStateCookie carries the signed OIDC state from the start of a login to
+// its callback. oidcStateTTL bounds how long the user may take at the
+// provider.
+const oidcStateCookie = "chirpy_oidc"
+const oidcStateTTL = 10 * time.Minute
+
+const identitiesSubjectConstraint = "identities_provider_subject_key"
+const identitiesProviderConstraint = "identities_user_id_provider_key"
+
+type outputIdentity struct {
+	Provider    string    `json:"provider"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+func newIdentity(identity database.Identity) outputIdentity {
+	return outputIdentity{
+		Provider:    identity.Provider,
+		Email:       identity.Email,
+		CreatedAt:   identity.CreatedAt,
+		LastLoginAt: identity.LastLoginAt,
+	}
+}
+
+// startOIDC sends the user to the identity provider.
+func (cfg *ApiConfig) startOIDC(w http.ResponseWriter, r *http.Request) {
+	authURL, ok := cfg.beginOIDC(w, r, uuid.NullUUID{})
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// linkOIDC starts the same flow for a logged in user, whose account gets the
+// provider identity instead of a new session. The client navigates to the
+// returned URL.
+func (cfg *ApiConfig) linkOIDC(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	authURL, ok := cfg.beginOIDC(w, r, uuid.NullUUID{UUID: userID, Valid: true})
+	if !ok {
+		return
+	}
+	OkResponse(w, struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}{
+		AuthorizationURL: authURL,
+	})
+}
+
+func (cfg *ApiConfig) beginOIDC(w http.ResponseWriter, r *http.Request, linkUserID uuid.NullUUID) (string, bool) {
+	provider, ok := cfg.OIDCProviders[r.PathValue("provider")]
+	if !ok {
+		NotFoundResponse(w, "Unknown identity provider.")
+		return "", false
+	}
+	state := auth.OIDCState{Provider: provider.Name(), LinkUserID: linkUserID}
+	var err error
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if *value, err = oidc.RandomString(); err != nil {
+			InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+			return "", false
+		}
+	}
+	authURL, err := provider.AuthCodeURL(r.Context(), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		slog.Error("Could not reach identity provider", "provider", provider.Name(), "error", err)
+		RespondWithError(w, http.StatusBadGateway, "Identity provider unavailable. Try again later.")
+		return "", false
+	}
+	signed, err := cfg.Keys.MakeOIDCState(state, oidcStateTTL)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return "", false
+	}
+	cfg.setOIDCStateCookie(w, signed, int(oidcStateTTL.Seconds()))
+	return authURL, true
+}
+
+func (cfg *ApiConfig) setOIDCStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	// Lax still sends the cookie on the top level redirect back from the
+	// provider.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oidcCallback finishes the flow: it checks that the callback answers the
+// request this browser started, exchanges the code and logs in, signs up or
+// links the account.
+func (cfg *ApiConfig) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.OIDCProviders[r.PathValue("provider")]
+	if !ok {
+		NotFoundResponse(w, "Unknown identity provider.")
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		BadRequestResponse(w, "Login expired. Start again.")
+		return
+	}
+	cfg.setOIDCStateCookie(w, "", -1)
+	state, err := cfg.Keys.ValidateOIDCState(cookie.Value)
+	if err != nil || state.Provider != provider.Name() {
+		BadRequestResponse(w, "Login expired. Start again.")
+		return
+	}
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		BadRequestResponse(w, "Invalid state.")
+		return
+	}
+	if providerError := query.Get("error"); providerError != "" {
+		UnauthorizedResponse(w, "The identity provider refused the login: "+providerError)
+		return
+	}
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		slog.Error("Could not complete login with identity provider", "provider", provider.Name(), "error", err)
+		UnauthorizedResponse(w, "Could not log in with the identity provider.")
+		return
+	}
+	if state.LinkUserID.Valid {
+		cfg.linkIdentity(w, r, state.LinkUserID.UUID, provider.Name(), claims)
+		return
+	}
+	dbUser, err := cfg.DB.GetUserByIdentity(r.Context(), database.GetUserByIdentityParams{
+		Provider: provider.Name(),
+		Subject:  claims.Subject,
+	})
+	if err == nil {
+		err = cfg.DB.TouchIdentity(r.Context(), database.TouchIdentityParams{
+			Provider: provider.Name(),
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		})
+		if err != nil {
+			slog.Error("Could not update identity", "provider", provider.Name(), "user_id", dbUser.ID, "error", err)
+		}
+		cfg.completeLogin(w, r, dbUser)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	cfg.signUpWithIdentity(w, r, provider.Name(), claims)
+}
+
+// signUpWithIdentity handles the first login with an identity. An account
+// with the same email only gets the identity when both sides verified the
+// address: otherwise whoever registered the email first could take over the
+// account of its real owner, or the other way around.
+func (cfg *ApiConfig) signUpWithIdentity(w http.ResponseWriter, r *http.Request, provider string, claims oidc.Claims) {
+	if claims.Email == "" || !claims.EmailVerified {
+		ForbiddenResponse(w, "The identity provider did not confirm your email address.")
+		return
+	}
+	dbUser, err := cfg.DB.GetUserByEmail(r.Context(), claims.Email)
+	switch {
+	case err == nil:
+		if !dbUser.EmailVerifiedAt.Valid {
+			RespondWithError(w, http.StatusConflict, "An account with this email already exists. Log in with your password and link the provider from your account.")
+			return
+		}
+		logSecurityEvent("identity_linked_by_email", "user_id", dbUser.ID, "provider", provider)
+	case errors.Is(err, sql.ErrNoRows):
+		dbUser, err = cfg.createUserFromIdentity(r, claims)
+		if err != nil {
+			slog.Error("Could not create user from identity", "provider", provider, "error", err)
+			InternalServerErrorResponse(w, "Error on login. Try again later.")
+			return
+		}
+	default:
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	_, err = cfg.DB.CreateIdentity(r.Context(), database.CreateIdentityParams{
+		UserID:   dbUser.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == identitiesProviderConstraint {
+			RespondWithError(w, http.StatusConflict, "Your account is linked to another "+provider+" account.")
+			return
+		}
+		InternalServerErrorResponse(w, "Error on login. Try again later.")
+		return
+	}
+	cfg.completeLogin(w, r, dbUser)
+}
+
+// createUserFromIdentity signs up a user without a password. They can set
+// one later with a password reset.
+func (cfg *ApiConfig) createUserFromIdentity(r *http.Request, claims oidc.Claims) (database.User, error) {
+	displayName := claims.Name
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		displayName = ""
+	}
+	dbUser, err := cfg.DB.CreateUser(r.Context(), database.CreateUserParams{
+		Email:       claims.Email,
+		Handle:      generatedHandle(),
+		DisplayName: displayName,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	return cfg.DB.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    dbUser.ID,
+		Email: dbUser.Email,
+	})
+}
+
+func (cfg *ApiConfig) linkIdentity(w http.ResponseWriter, r *http.Request, userID uuid.UUID, provider string, claims oidc.Claims) {
+	identity, err := cfg.DB.CreateIdentity(r.Context(), database.CreateIdentityParams{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		switch {
+		case ok && pqErr.Constraint == identitiesSubjectConstraint:
+			RespondWithError(w, http.StatusConflict, "This "+provider+" account is linked to another user.")
+		case ok && pqErr.Constraint == identitiesProviderConstraint:
+			RespondWithError(w, http.StatusConflict, "Your account is already linked to "+provider+".")
+		default:
+			InternalServerErrorResponse(w, "Could not link account. Try again later.")
+		}
+		return
+	}
+	logSecurityEvent("identity_linked", "user_id", userID, "provider", provider)
+	RespondWithJSON(w, http.StatusCreated, newIdentity(identity))
+}
+
+func (cfg *ApiConfig) listIdentities(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	identities, err := cfg.DB.ListUserIdentities(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not list linked accounts. Try again later.")
+		return
+	}
+	output := make([]outputIdentity, len(identities))
+	for i, identity := range identities {
+		output[i] = newIdentity(identity)
+	}
+	OkResponse(w, output)
+}
+
+// unlinkIdentity refuses to remove the last way a user without a password
+// has to log in.
+func (cfg *ApiConfig) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	provider := r.PathValue("provider")
+	dbUser, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not unlink account. Try again later.")
+		return
+	}
+	identities, err := cfg.DB.ListUserIdentities(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not unlink account. Try again later.")
+		return
+	}
+	if dbUser.HashedPassword == "" && len(identities) == 1 && identities[0].Provider == provider {
+		RespondWithError(w, http.StatusConflict, "Set a password before unlinking your only way to log in.")
+		return
+	}
+	deleted, err := cfg.DB.DeleteIdentity(r.Context(), database.DeleteIdentityParams{
+		UserID:   userID,
+		Provider: provider,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not unlink account. Try again later.")
+		return
+	}
+	if deleted == 0 {
+		NotFoundResponse(w, "Account not linked.")
+		return
+	}
+	logSecurityEvent("identity_unlinked", "user_id", userID, "provider", provider)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,355 @@
+package api_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/JP-Go/http-server-go/internal/api"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/oidc"
+	"github.com/JP-Go/http-server-go/internal/totp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const mockProviderName = "mock"
+
+// mockIdP is an OpenID provider that signs in whoever it is told to. The
+// authorization step is played by authorize, since there is no browser.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// claims are added to the ID token of the next login.
+	claims    jwt.MapClaims
+	code      string
+	challenge string
+	nonce     string
+	audience  string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if m.code == "" || r.PostForm.Get("code") != m.code || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		m.code = ""
+		claims := jwt.MapClaims{
+			"iss":   m.server.URL,
+			"aud":   m.audience,
+			"nonce": m.nonce,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+		for name, value := range m.claims {
+			claims[name] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "mock"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// provider is how Chirpy is configured to use the mock.
+func (m *mockIdP) provider() *oidc.Provider {
+	return oidc.NewProvider(oidc.Config{
+		Name:        mockProviderName,
+		Issuer:      m.server.URL,
+		ClientID:    "chirpy",
+		RedirectURL: testBaseURL + "/api/auth/" + mockProviderName + "/callback",
+	}, m.server.Client())
+}
+
+// authorize plays the part of the user signing in at the provider and returns
+// the callback URL the provider redirects back to.
+func (m *mockIdP) authorize(t *testing.T, authURL string) *url.URL {
+	t.Helper()
+	if !strings.HasPrefix(authURL, m.server.URL+"/authorize?") {
+		t.Fatalf("Login was sent to %s instead of the provider", authURL)
+	}
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	m.code = "code-" + query.Get("state")
+	m.challenge = query.Get("code_challenge")
+	m.nonce = query.Get("nonce")
+	m.audience = query.Get("client_id")
+	callback, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		t.Fatalf("Invalid redirect_uri: %s", err)
+	}
+	callback.RawQuery = url.Values{"code": {m.code}, "state": {query.Get("state")}}.Encode()
+	return callback
+}
+
+func newOIDCTestServer(t *testing.T) (*testServer, *mockIdP) {
+	t.Helper()
+	idp := newMockIdP(t)
+	s := newTestServer(t, func(cfg *api.ApiConfig) {
+		cfg.OIDCProviders = map[string]*oidc.Provider{mockProviderName: idp.provider()}
+	})
+	return s, idp
+}
+
+// startOIDCLogin goes through the start endpoint and the provider, and
+// returns the callback request the browser would make next.
+func startOIDCLogin(t *testing.T, s *testServer, idp *mockIdP) *http.Request {
+	t.Helper()
+	w := s.do(http.MethodGet, "/api/auth/"+mockProviderName+"/start", "", nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET start = %d, expected %d: %s", w.Code, http.StatusFound, w.Body)
+	}
+	var stateCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "chirpy_oidc" {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly || stateCookie.Path != "/api/auth/" {
+		t.Fatalf("Start should set an HttpOnly state cookie for /api/auth/, got %+v", stateCookie)
+	}
+	callback := idp.authorize(t, w.Header().Get("Location"))
+	r := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	r.AddCookie(&http.Cookie{Name: stateCookie.Name, Value: stateCookie.Value})
+	return r
+}
+
+func (s *testServer) serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+func decodeLogin(t *testing.T, s *testServer, w *httptest.ResponseRecorder) api.LoginResponseBody {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Login = %d, expected %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var body api.LoginResponseBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Login response is not JSON: %s", err)
+	}
+	if body.Token == "" || body.RefreshToken == "" {
+		t.Fatalf("Login should return an access and a refresh token, got %+v", body)
+	}
+	if _, err := s.config.Keys.ParseAccessToken(body.Token); err != nil {
+		t.Fatalf("Login returned an invalid access token: %s", err)
+	}
+	return body
+}
+
+func identityRow(user database.User, subject string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "user_id", "provider", "subject", "email", "created_at", "last_login_at"}).
+		AddRow(user.ID, user.ID, mockProviderName, subject, user.Email, now, now)
+}
+
+func userTOTPRow(user database.User, secret string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user_id", "secret", "created_at", "enabled_at", "last_used_step"}).
+		AddRow(user.ID, secret, time.Now(), time.Now(), 0)
+}
+
+func expectSessionStart(s *testServer) {
+	s.expectQuery("CreateRefreshToken").WillReturnRows(sqlmock.NewRows([]string{"token_hash"}).AddRow("hash"))
+}
+
+func Test_OIDCLoginLinksAccountWithTheSameVerifiedEmail(t *testing.T) {
+	s, idp := newOIDCTestServer(t)
+	user := newTestUser()
+	user.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": user.Email, "email_verified": true}
+
+	callback := startOIDCLogin(t, s, idp)
+	s.expectQuery("GetUserByIdentity").WithArgs(mockProviderName, "subject-1").WillReturnError(sql.ErrNoRows)
+	s.expectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(userRow(user))
+	s.expectQuery("CreateIdentity").WithArgs(user.ID, mockProviderName, "subject-1", user.Email).WillReturnRows(identityRow(user, "subject-1"))
+	s.expectQuery("GetUserTOTP").WillReturnError(sql.ErrNoRows)
+	expectSessionStart(s)
+	w := s.serve(callback)
+	body := decodeLogin(t, s, w)
+	if body.User.ID != user.ID {
+		t.Errorf("Logged in as %s, expected the existing account %s", body.User.ID, user.ID)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "chirpy_oidc" && cookie.MaxAge >= 0 {
+			t.Error("The callback should delete the state cookie")
+		}
+	}
+	s.checkExpectations(t)
+}
+
+func Test_OIDCLoginDoesNotLinkAnUnverifiedAccount(t *testing.T) {
+	s, idp := newOIDCTestServer(t)
+	user := newTestUser()
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": user.Email, "email_verified": true}
+
+	callback := startOIDCLogin(t, s, idp)
+	s.expectQuery("GetUserByIdentity").WillReturnError(sql.ErrNoRows)
+	s.expectQuery("GetUserByEmail").WithArgs(user.Email).WillReturnRows(userRow(user))
+	w := s.serve(callback)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Callback = %d, expected %d", w.Code, http.StatusConflict)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_OIDCLoginRequiresAVerifiedEmailFromTheProvider(t *testing.T) {
+	s, idp := newOIDCTestServer(t)
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": "walt@example.com", "email_verified": false}
+
+	callback := startOIDCLogin(t, s, idp)
+	s.expectQuery("GetUserByIdentity").WillReturnError(sql.ErrNoRows)
+	w := s.serve(callback)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Callback = %d, expected %d", w.Code, http.StatusForbidden)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_OIDCLoginSignsUpNewUsers(t *testing.T) {
+	s, idp := newOIDCTestServer(t)
+	user := newTestUser()
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": user.Email, "email_verified": "true", "name": "Walt"}
+
+	callback := startOIDCLogin(t, s, idp)
+	verified := user
+	verified.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	s.expectQuery("GetUserByIdentity").WillReturnError(sql.ErrNoRows)
+	s.expectQuery("GetUserByEmail").WillReturnError(sql.ErrNoRows)
+	s.expectQuery("CreateUser").WillReturnRows(userRow(user))
+	s.expectQuery("VerifyUserEmail").WithArgs(user.ID, user.Email).WillReturnRows(userRow(verified))
+	s.expectQuery("CreateIdentity").WillReturnRows(identityRow(user, "subject-1"))
+	s.expectQuery("GetUserTOTP").WillReturnError(sql.ErrNoRows)
+	expectSessionStart(s)
+	decodeLogin(t, s, s.serve(callback))
+	s.checkExpectations(t)
+}
+
+func Test_OIDCLoginAsksForTheSecondFactor(t *testing.T) {
+	s, idp := newOIDCTestServer(t)
+	user := newTestUser()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": user.Email, "email_verified": true}
+
+	callback := startOIDCLogin(t, s, idp)
+	s.expectQuery("GetUserByIdentity").WithArgs(mockProviderName, "subject-1").WillReturnRows(userRow(user))
+	s.expectExec("TouchIdentity").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectQuery("GetUserTOTP").WithArgs(user.ID).WillReturnRows(userTOTPRow(user, secret))
+	w := s.serve(callback)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Callback = %d, expected %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var challenge api.MFAChallengeResponseBody
+	json.NewDecoder(w.Body).Decode(&challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("Callback should ask for the second factor, got %+v", challenge)
+	}
+	if strings.Contains(w.Body.String(), "refresh_token") {
+		t.Fatal("Callback must not log in before the second factor")
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.expectQuery("GetUserByID").WithArgs(user.ID).WillReturnRows(userRow(user))
+	s.expectQuery("GetUserTOTP").WithArgs(user.ID).WillReturnRows(userTOTPRow(user, secret))
+	s.expectExec("UseTOTPStep").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSessionStart(s)
+	decodeLogin(t, s, s.do(http.MethodPost, "/api/login/mfa", "", map[string]string{
+		"mfa_token": challenge.MFAToken,
+		"code":      code,
+	}))
+	s.checkExpectations(t)
+}
+
+func Test_OIDCCallbackChecksTheState(t *testing.T) {
+	s, idp := newOIDCTestServer(t)
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": "walt@example.com", "email_verified": true}
+	callback := startOIDCLogin(t, s, idp)
+
+	withoutCookie := httptest.NewRequest(http.MethodGet, callback.URL.RequestURI(), nil)
+	if w := s.serve(withoutCookie); w.Code != http.StatusBadRequest {
+		t.Errorf("Callback without the state cookie = %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+
+	query := callback.URL.Query()
+	query.Set("state", "forged")
+	forged := httptest.NewRequest(http.MethodGet, callback.URL.Path+"?"+query.Encode(), nil)
+	for _, cookie := range callback.Cookies() {
+		forged.AddCookie(cookie)
+	}
+	if w := s.serve(forged); w.Code != http.StatusBadRequest {
+		t.Errorf("Callback with another state = %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+
+	// The state cookie of one provider is no good for another.
+	other := httptest.NewRequest(http.MethodGet, "/api/auth/other/callback?"+callback.URL.RawQuery, nil)
+	if w := s.serve(other); w.Code != http.StatusNotFound {
+		t.Errorf("Callback of an unknown provider = %d, expected %d", w.Code, http.StatusNotFound)
+	}
+	s.checkExpectations(t)
+}
+
+func Test_OIDCCallbackRejectsAReplayedCode(t *testing.T) {
+	s, idp := newOIDCTestServer(t)
+	user := newTestUser()
+	idp.claims = jwt.MapClaims{"sub": "subject-1", "email": user.Email, "email_verified": true}
+	callback := startOIDCLogin(t, s, idp)
+
+	s.expectQuery("GetUserByIdentity").WillReturnRows(userRow(user))
+	s.expectExec("TouchIdentity").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectQuery("GetUserTOTP").WillReturnError(sql.ErrNoRows)
+	expectSessionStart(s)
+	decodeLogin(t, s, s.serve(callback))
+
+	replay := httptest.NewRequest(http.MethodGet, callback.URL.RequestURI(), nil)
+	for _, cookie := range callback.Cookies() {
+		replay.AddCookie(cookie)
+	}
+	if w := s.serve(replay); w.Code != http.StatusUnauthorized {
+		t.Errorf("Replayed callback = %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+	s.checkExpectations(t)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultPageLimit = 50
+const maxPageLimit = 100
+
+// nextCursorHeader carries the cursor of the next page for GET /api/chirps,
+// whose body stays the bare array of chirps it was before pagination.
+const nextCursorHeader = "X-Next-Cursor"
+
+const (
+	sortAsc       = "asc"
+	sortDesc      = "desc"
+	sortRelevance = "relevance"
+)
+
+// pageCursor marks the last row of a page. It is handed to clients as an
+// opaque string and used as the keyset for the next query.
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	Rank      float32   `json:"r,omitempty"`
+}
+
+type pageParams struct {
+	Limit  int32
+	Sort   string
+	Cursor *pageCursor
+}
+
+// parseBoundedInt parses an optional positive query parameter.
+func parseBoundedInt(value string, fallback, maximum int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 || parsed > maximum {
+		return 0, errors.New("Out of range")
+	}
+	return parsed, nil
+}
+
+func encodeCursor(cursor pageCursor) string {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string) (pageCursor, error) {
+	var cursor pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, errors.New("Invalid cursor")
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, errors.New("Invalid cursor")
+	}
+	return cursor, nil
+}
+
+func parsePageParams(r *http.Request) (pageParams, error) {
+	query := r.URL.Query()
+	params := pageParams{Limit: defaultPageLimit}
+
+	limit, err := parseBoundedInt(query.Get("limit"), defaultPageLimit, maxPageLimit)
+	if err != nil {
+		return params, errors.New("limit must be a number between 1 and " + strconv.Itoa(maxPageLimit))
+	}
+	params.Limit = int32(limit)
+
+	switch sort := query.Get("sort"); sort {
+	case "", sortAsc, sortDesc, sortRelevance:
+		params.Sort = sort
+	default:
+		return params, errors.New("sort must be one of asc, desc or relevance")
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		decoded, err := decodeCursor(cursor)
+		if err != nil {
+			return params, err
+		}
+		params.Cursor = &decoded
+	}
+	return params, nil
+}
+
+// parseNewestFirstPageParams is parsePageParams for lists that only come
+// newest first. Other sort orders are rejected instead of ignored.
+func parseNewestFirstPageParams(r *http.Request) (pageParams, error) {
+	params, err := parsePageParams(r)
+	if err == nil && params.Sort != "" && params.Sort != sortDesc {
+		return params, errors.New("sort must be desc: this list only comes newest first")
+	}
+	return params, err
+}
+
+// Keyset returns the cursor as nullable query parameters.
+func (p pageParams) Keyset() (sql.NullTime, uuid.NullUUID) {
+	if p.Cursor == nil {
+		return sql.NullTime{}, uuid.NullUUID{}
+	}
+	return sql.NullTime{Time: p.Cursor.CreatedAt, Valid: true},
+		uuid.NullUUID{UUID: p.Cursor.ID, Valid: true}
+}
+
+// KeysetRank returns the relevance part of the cursor for ranked searches.
+func (p pageParams) KeysetRank() sql.NullFloat64 {
+	if p.Cursor == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: float64(p.Cursor.Rank), Valid: true}
+}
+
+// FetchLimit asks for one extra row so we know whether there is a next page.
+func (p pageParams) FetchLimit() int32 {
+	return p.Limit + 1
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxPersonalTokenNameLength = 100
+const maxPersonalTokensPerUser = 50
+
+// personalTokenTouchInterval keeps busy bots from writing last_used_at on
+// every request.
+const personalTokenTouchInterval = time.Minute
+
+var errInvalidPersonalToken = errors.New("Invalid personal access token")
+
+type CreatePersonalTokenRequestBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is optional. Tokens without it are valid until deleted.
+	ExpiresInDays *int `json:"expires_in_days"`
+}
+
+type outputPersonalToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// Token is only sent when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func newPersonalToken(token database.PersonalAccessToken) outputPersonalToken {
+	output := outputPersonalToken{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if token.ExpiresAt.Valid {
+		output.ExpiresAt = &token.ExpiresAt.Time
+	}
+	if token.LastUsedAt.Valid {
+		output.LastUsedAt = &token.LastUsedAt.Time
+	}
+	return output
+}
+
+// authenticatePersonalToken looks up a personal access token presented as a
+// bearer token.
+func (api *ApiConfig) authenticatePersonalToken(ctx context.Context, token string) (database.PersonalAccessToken, error) {
+	personalToken, err := api.DB.GetPersonalAccessToken(ctx, auth.HashPersonalToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.PersonalAccessToken{}, errInvalidPersonalToken
+		}
+		return database.PersonalAccessToken{}, err
+	}
+	if personalToken.ExpiresAt.Valid && personalToken.ExpiresAt.Time.Before(time.Now()) {
+		return database.PersonalAccessToken{}, errInvalidPersonalToken
+	}
+	if !personalToken.LastUsedAt.Valid || time.Since(personalToken.LastUsedAt.Time) > personalTokenTouchInterval {
+		if err := api.DB.TouchPersonalAccessToken(ctx, personalToken.ID); err != nil {
+			slog.Error("Could not record personal token use", "token_id", personalToken.ID, "error", err)
+		}
+	}
+	return personalToken, nil
+}
+
+func (api *ApiConfig) createPersonalToken(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	var body CreatePersonalTokenRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	if body.Name == "" || utf8.RuneCountInString(body.Name) > maxPersonalTokenNameLength {
+		BadRequestResponse(w, "Name must have between 1 and 100 characters.")
+		return
+	}
+	scopes, err := auth.ValidateScopes(body.Scopes)
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	var expiresAt sql.NullTime
+	if body.ExpiresInDays != nil {
+		if *body.ExpiresInDays < 1 || *body.ExpiresInDays > 366 {
+			BadRequestResponse(w, "expires_in_days must be between 1 and 366.")
+			return
+		}
+		expiresAt = sql.NullTime{Time: time.Now().UTC().AddDate(0, 0, *body.ExpiresInDays), Valid: true}
+	}
+	existing, err := api.DB.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not create token. Try again later.")
+		return
+	}
+	if len(existing) >= maxPersonalTokensPerUser {
+		BadRequestResponse(w, "Too many personal access tokens. Delete one first.")
+		return
+	}
+	token, err := auth.MakePersonalToken()
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not create token. Try again later.")
+		return
+	}
+	personalToken, err := api.DB.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      body.Name,
+		TokenHash: auth.HashPersonalToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not create token. Try again later.")
+		return
+	}
+	output := newPersonalToken(personalToken)
+	output.Token = token
+	RespondWithJSON(w, http.StatusCreated, output)
+}
+
+func (api *ApiConfig) listPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	tokens, err := api.DB.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not list tokens. Try again later.")
+		return
+	}
+	output := make([]outputPersonalToken, len(tokens))
+	for i, token := range tokens {
+		output[i] = newPersonalToken(token)
+	}
+	OkResponse(w, output)
+}
+
+func (api *ApiConfig) deletePersonalToken(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid tokenID")
+		return
+	}
+	deleted, err := api.DB.DeletePersonalAccessToken(r.Context(), database.DeletePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not delete token. Try again later.")
+		return
+	}
+	if deleted == 0 {
+		NotFoundResponse(w, "Token not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
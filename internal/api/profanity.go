@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/profanity"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Rules can be changed by other instances, so every instance also reloads
+// them periodically.
+const profanityReloadInterval = time.Minute
+const maxProfanityPatternLength = 256
+
+type ProfanityRuleRequestBody struct {
+	Pattern   string `json:"pattern"`
+	MatchType string `json:"match_type"`
+}
+
+type outputProfanityRule struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Pattern   string    `json:"pattern"`
+	MatchType string    `json:"match_type"`
+}
+
+func newOutputProfanityRule(rule database.ProfanityRule) outputProfanityRule {
+	return outputProfanityRule{
+		ID:        rule.ID,
+		CreatedAt: rule.CreatedAt,
+		Pattern:   rule.Pattern,
+		MatchType: rule.MatchType,
+	}
+}
+
+// reloadProfanityFilter swaps the in-memory filter for one built from the
+// rules currently stored in the database.
+func (cfg *ApiConfig) reloadProfanityFilter(ctx context.Context) error {
+	dbRules, err := cfg.DB.ListProfanityRules(ctx)
+	if err != nil {
+		return err
+	}
+	rules := make([]profanity.Rule, len(dbRules))
+	for i, rule := range dbRules {
+		rules[i] = profanity.Rule{Pattern: rule.Pattern, MatchType: rule.MatchType}
+	}
+	filter, err := profanity.NewFilter(rules, profanity.DefaultReplacement)
+	if err != nil {
+		return err
+	}
+	cfg.profanityFilter.Store(filter)
+	return nil
+}
+
+func (cfg *ApiConfig) watchProfanityRules(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cfg.reloadProfanityFilter(ctx); err != nil {
+			slog.Error("Could not reload profanity rules", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *ApiConfig) listProfanityRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := cfg.DB.ListProfanityRules(r.Context())
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	output := make([]outputProfanityRule, len(rules))
+	for i, rule := range rules {
+		output[i] = newOutputProfanityRule(rule)
+	}
+	OkResponse(w, output)
+}
+
+func (cfg *ApiConfig) createProfanityRule(w http.ResponseWriter, r *http.Request) {
+	var body ProfanityRuleRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Request body is not valid JSON.")
+		return
+	}
+	if body.MatchType == "" {
+		body.MatchType = profanity.MatchWord
+	}
+	if len(body.Pattern) > maxProfanityPatternLength {
+		BadRequestResponse(w, "Pattern too long")
+		return
+	}
+	rule := profanity.Rule{Pattern: body.Pattern, MatchType: body.MatchType}
+	if err := profanity.ValidateRule(rule); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	dbRule, err := cfg.DB.CreateProfanityRule(r.Context(), database.CreateProfanityRuleParams{
+		Pattern:   rule.Pattern,
+		MatchType: rule.MatchType,
+	})
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			RespondWithError(w, http.StatusConflict, "Rule already exists.")
+			return
+		}
+		InternalServerErrorResponse(w, "Could not create rule. Try again later")
+		return
+	}
+	if err := cfg.reloadProfanityFilter(r.Context()); err != nil {
+		slog.Error("Could not reload profanity rules", "error", err)
+	}
+	RespondWithJSON(w, http.StatusCreated, newOutputProfanityRule(dbRule))
+}
+
+func (cfg *ApiConfig) deleteProfanityRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid ruleID")
+		return
+	}
+	deleted, err := cfg.DB.DeleteProfanityRule(r.Context(), ruleID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not delete rule. Try again later")
+		return
+	}
+	if deleted == 0 {
+		NotFoundResponse(w, "Rule not found")
+		return
+	}
+	if err := cfg.reloadProfanityFilter(r.Context()); err != nil {
+		slog.Error("Could not reload profanity rules", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
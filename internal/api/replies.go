@@ -0,0 +1,92 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const defaultReplyDepth = 3
+const maxReplyDepth = 10
+
+type threadNode struct {
+	outputChirp
+	Replies []*threadNode `json:"replies"`
+}
+
+type threadOutput struct {
+	Chirp   outputChirp   `json:"chirp"`
+	Replies []*threadNode `json:"replies"`
+}
+
+// getReplies returns the reply tree below a chirp. Deleted chirps are kept in
+// the tree as tombstones so their replies are not orphaned.
+func (api *ApiConfig) getReplies(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid chirpID")
+		return
+	}
+	depth := defaultReplyDepth
+	if rawDepth := r.URL.Query().Get("depth"); rawDepth != "" {
+		depth, err = strconv.Atoi(rawDepth)
+		if err != nil || depth < 1 || depth > maxReplyDepth {
+			BadRequestResponse(w, "depth must be a number between 1 and "+strconv.Itoa(maxReplyDepth))
+			return
+		}
+	}
+
+	root, err := api.DB.FindChirpByID(r.Context(), chirpID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			NotFoundResponse(w, "Chirp not found")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error")
+		}
+		return
+	}
+	rows, err := api.DB.GetReplyTree(r.Context(), database.GetReplyTreeParams{
+		RootID:   root.ID,
+		MaxDepth: int32(depth),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+
+	chirps := make([]outputChirp, 0, len(rows)+1)
+	chirps = append(chirps, newOutputChirp(root))
+	for _, row := range rows {
+		chirps = append(chirps, newOutputChirp(database.Chirp{
+			ID:        row.ID,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+			Body:      row.Body,
+			UserID:    row.UserID,
+			ReplyTo:   row.ReplyTo,
+			DeletedAt: row.DeletedAt,
+		}))
+	}
+	if err := api.hydrateChirps(r.Context(), api.viewerIDFromRequest(r), chirps); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+
+	// Rows come ordered by depth, so every parent is indexed before its replies.
+	nodes := map[uuid.UUID]*threadNode{root.ID: {outputChirp: chirps[0], Replies: []*threadNode{}}}
+	for _, chirp := range chirps[1:] {
+		node := &threadNode{outputChirp: chirp, Replies: []*threadNode{}}
+		nodes[chirp.ID] = node
+		if parent, ok := nodes[*chirp.ReplyTo]; ok {
+			parent.Replies = append(parent.Replies, node)
+		}
+	}
+	OkResponse(w, threadOutput{
+		Chirp:   chirps[0],
+		Replies: nodes[root.ID].Replies,
+	})
+}
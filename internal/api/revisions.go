@@ -0,0 +1,99 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+type outputRevision struct {
+	ID        uuid.UUID `json:"id"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// editChirp replaces the body of a chirp, keeping the previous body as a
+// revision.
+func (api *ApiConfig) editChirp(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	chirp, ok := api.findPathChirp(w, r)
+	if !ok {
+		return
+	}
+	if chirp.UserID.UUID != userID {
+		ForbiddenResponse(w, "Chirp does not belong to your user")
+		return
+	}
+
+	var body inputChirp
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		BadRequestResponse(w, "Error decoding parameters. Invalid JSON")
+		return
+	}
+	validChirp, err := ValidateChirp(NewChirp(body.Body))
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	validChirp, err = CleanChirp(validChirp, api.profanityFilter.Load())
+	if err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+
+	edited := chirp
+	if validChirp.content != chirp.Body {
+		err = api.inTx(r.Context(), func(queries *database.Queries) error {
+			edited, err = queries.EditChirp(r.Context(), database.EditChirpParams{
+				ID:   chirp.ID,
+				Body: validChirp.content,
+			})
+			if err != nil {
+				return err
+			}
+			return saveEntities(r.Context(), queries, edited.ID, edited.Body)
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				NotFoundResponse(w, "Chirp not found")
+			} else {
+				InternalServerErrorResponse(w, "Could not edit chirp. Try again later")
+			}
+			return
+		}
+	}
+	output := []outputChirp{newOutputChirp(edited)}
+	if err := api.hydrateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, output); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	OkResponse(w, output[0])
+}
+
+func (api *ApiConfig) getChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirp, ok := api.findPathChirp(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := api.DB.ListChirpRevisions(r.Context(), chirp.ID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	output := make([]outputRevision, len(revisions))
+	for i, revision := range revisions {
+		output[i] = outputRevision{
+			ID:        revision.ID,
+			ChirpID:   revision.ChirpID,
+			Body:      revision.Body,
+			CreatedAt: revision.CreatedAt,
+		}
+	}
+	OkResponse(w, output)
+}
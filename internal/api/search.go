@@ -0,0 +1,112 @@
+package api
+
+import (
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxSearchQueryLength = 256
+
+type searchResult struct {
+	outputChirp
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+type searchPage struct {
+	Chirps     []searchResult `json:"chirps"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// searchChirps answers GET /api/chirps?q=... Results are ordered by relevance
+// unless the caller explicitly asks for a chronological sort. Like the plain
+// listing, it answers with an array and the next cursor in a header.
+func (api *ApiConfig) searchChirps(w http.ResponseWriter, r *http.Request, query string, authorID uuid.NullUUID, page pageParams) {
+	if len(query) > maxSearchQueryLength {
+		BadRequestResponse(w, "Search query too long")
+		return
+	}
+	if page.Sort == "" {
+		page.Sort = sortRelevance
+	}
+
+	cursorCreatedAt, cursorID := page.Keyset()
+	rows, err := api.DB.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query:           query,
+		Sort:            page.Sort,
+		AuthorID:        authorID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorRank:      page.KeysetRank(),
+		CursorID:        cursorID,
+		PageLimit:       page.FetchLimit(),
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	var results []searchResult
+	for _, row := range rows {
+		results = append(results, searchResult{
+			outputChirp: newOutputChirp(database.Chirp{
+				ID:        row.ID,
+				CreatedAt: row.CreatedAt,
+				UpdatedAt: row.UpdatedAt,
+				Body:      row.Body,
+				UserID:    row.UserID,
+				ReplyTo:   row.ReplyTo,
+				DeletedAt: row.DeletedAt,
+			}),
+			Rank:    row.Rank,
+			Snippet: highlightSnippet(row.Snippet),
+		})
+	}
+
+	output := searchPage{Chirps: []searchResult{}}
+	if len(results) > int(page.Limit) {
+		results = results[:page.Limit]
+		last := results[len(results)-1]
+		cursor := pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		if page.Sort == sortRelevance {
+			cursor.Rank = last.Rank
+		}
+		output.NextCursor = encodeCursor(cursor)
+	}
+	if results != nil {
+		output.Chirps = results
+	}
+	chirps := make([]outputChirp, len(output.Chirps))
+	for i, result := range output.Chirps {
+		chirps[i] = result.outputChirp
+	}
+	if err := api.hydrateChirps(r.Context(), api.viewerIDFromRequest(r), chirps); err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	for i := range output.Chirps {
+		output.Chirps[i].outputChirp = chirps[i]
+	}
+	if output.NextCursor != "" {
+		w.Header().Set(nextCursorHeader, output.NextCursor)
+	}
+	OkResponse(w, output.Chirps)
+}
+
+// The search query marks matches with these control characters, which are
+// stripped from the body first, instead of HTML tags. The body can then be
+// escaped before the marks are turned into tags.
+const (
+	snippetStartSel = "\x02"
+	snippetStopSel  = "\x03"
+)
+
+var snippetMarks = strings.NewReplacer(snippetStartSel, "<mark>", snippetStopSel, "</mark>")
+
+// highlightSnippet returns a headline as HTML that is safe to render: the
+// chirp text is escaped and only the <mark> tags around matches are markup.
+func highlightSnippet(headline string) string {
+	return snippetMarks.Replace(html.EscapeString(headline))
+}
@@ -0,0 +1,9 @@
+package api
+
+import "log/slog"
+
+// logSecurityEvent records events worth an investigation, like a stolen
+// credential being replayed, in a format that is easy to alert on.
+func logSecurityEvent(event string, attrs ...any) {
+	slog.Warn("security event", append([]any{"event", event}, attrs...)...)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxUserAgentLength keeps a hostile client from filling the sessions table.
+const maxUserAgentLength = 512
+
+// A session is a refresh token family: it starts at login and survives every
+// rotation of its refresh token.
+type outputSession struct {
+	ID         uuid.UUID `json:"id"`
+	SignedInAt time.Time `json:"signed_in_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+// clientIP returns the address of the peer that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientUserAgent returns the User-Agent header as valid UTF-8, cut on a rune
+// boundary so that Postgres accepts it.
+func clientUserAgent(r *http.Request) string {
+	userAgent := strings.ToValidUTF8(r.UserAgent(), "\uFFFD")
+	if len(userAgent) > maxUserAgentLength {
+		cut := maxUserAgentLength
+		for !utf8.RuneStart(userAgent[cut]) {
+			cut--
+		}
+		userAgent = userAgent[:cut]
+	}
+	return userAgent
+}
+
+func (api *ApiConfig) listSessions(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	rows, err := api.DB.ListSessions(r.Context(), userID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not list sessions. Try again later")
+		return
+	}
+	sessions := make([]outputSession, len(rows))
+	for i, row := range rows {
+		sessions[i] = outputSession{
+			ID:         row.FamilyID,
+			SignedInAt: row.SignedInAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+		}
+	}
+	OkResponse(w, sessions)
+}
+
+// revokeSession logs one session out. Its refresh token stops working and so
+// do the access tokens issued for it.
+func (api *ApiConfig) revokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		BadRequestResponse(w, "Invalid sessionID")
+		return
+	}
+	revoked, err := api.DB.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not revoke session. Try again later")
+		return
+	}
+	if revoked == 0 {
+		NotFoundResponse(w, "Session not found")
+		return
+	}
+	api.endSession(sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAllSessions logs the user out everywhere: every refresh token is
+// revoked, every access token issued so far stops being accepted and every
+// personal access token is deleted.
+func (api *ApiConfig) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID := parseUserIDFromRequest(r)
+	if err := api.DB.RevokeAllSessions(r.Context(), userID); err != nil {
+		InternalServerErrorResponse(w, "Could not revoke sessions. Try again later")
+		return
+	}
+	if err := api.DB.DeleteUserPersonalAccessTokens(r.Context(), userID); err != nil {
+		InternalServerErrorResponse(w, "Could not revoke sessions. Try again later")
+		return
+	}
+	if err := api.revokeAccessTokens(r.Context(), userID); err != nil {
+		InternalServerErrorResponse(w, "Could not revoke sessions. Try again later")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/google/uuid"
+)
+
+// tokenVersionTTL bounds how long another instance may keep accepting tokens
+// after a version bump or the end of a session. Changes made by this instance
+// take effect immediately.
+const tokenVersionTTL = 30 * time.Second
+
+type cachedValue[V any] struct {
+	value     V
+	fetchedAt time.Time
+}
+
+// revocationCache keeps what the middleware read from the database about
+// recently seen users or sessions, so that it does not hit the database on
+// every request.
+type revocationCache[V any] struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	values    map[uuid.UUID]cachedValue[V]
+	lastSweep time.Time
+}
+
+func newRevocationCache[V any](ttl time.Duration) *revocationCache[V] {
+	return &revocationCache[V]{ttl: ttl, values: map[uuid.UUID]cachedValue[V]{}}
+}
+
+func (c *revocationCache[V]) get(id uuid.UUID) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.values[id]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	if time.Since(cached.fetchedAt) > c.ttl {
+		delete(c.values, id)
+		var zero V
+		return zero, false
+	}
+	return cached.value, true
+}
+
+func (c *revocationCache[V]) set(id uuid.UUID, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	c.values[id] = cachedValue[V]{value: value, fetchedAt: now}
+}
+
+// sweep drops expired entries at most once per ttl, so that the cache only
+// holds what was seen recently.
+func (c *revocationCache[V]) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for id, cached := range c.values {
+		if now.Sub(cached.fetchedAt) > c.ttl {
+			delete(c.values, id)
+		}
+	}
+}
+
+func (c *revocationCache[V]) forget(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, id)
+}
+
+// accessTokenRevoked reports whether the token was issued for an older
+// version of the user's tokens, or for a session that has ended since.
+func (api *ApiConfig) accessTokenRevoked(ctx context.Context, token auth.AccessToken) (bool, error) {
+	version, ok := api.tokenVersions.get(token.UserID)
+	if !ok {
+		var err error
+		version, err = api.DB.GetUserTokenVersion(ctx, token.UserID)
+		if err != nil {
+			return false, err
+		}
+		api.tokenVersions.set(token.UserID, version)
+	}
+	if token.TokenVersion != version {
+		return true, nil
+	}
+	active, ok := api.activeSessions.get(token.SessionID)
+	if !ok {
+		var err error
+		active, err = api.DB.SessionIsActive(ctx, token.SessionID)
+		if err != nil {
+			return false, err
+		}
+		api.activeSessions.set(token.SessionID, active)
+	}
+	return !active, nil
+}
+
+// endSession stops the access tokens of a session that was just revoked from
+// being accepted by this instance.
+func (api *ApiConfig) endSession(sessionID uuid.UUID) {
+	api.activeSessions.set(sessionID, false)
+}
+
+// revokeAccessTokens invalidates every access token issued to the user so far.
+func (api *ApiConfig) revokeAccessTokens(ctx context.Context, userID uuid.UUID) error {
+	version, err := api.DB.BumpTokenVersion(ctx, userID)
+	if err != nil {
+		api.tokenVersions.forget(userID)
+		return err
+	}
+	api.tokenVersions.set(userID, version)
+	return nil
+}
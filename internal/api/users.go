@@ -1,30 +1,155 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/JP-Go/http-server-go/internal/chirptext"
 	"github.com/JP-Go/http-server-go/internal/database"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 )
 
+const maxDisplayNameLength = 50
+const maxBioLength = 160
+const maxAvatarURLLength = 2048
+
+const usersEmailConstraint = "users_email_key"
+const usersHandleConstraint = "users_handle_lower_idx"
+
 type User struct {
-	ID          uuid.UUID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
-	IsChirpyRed bool      `json:"is_chirpy_red"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Handle        string    `json:"handle"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio"`
+	AvatarURL     string    `json:"avatar_url"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
 }
 
-type CreateUserRequestBody struct {
+func newUser(dbUser database.User) User {
+	return User{
+		ID:            dbUser.ID,
+		CreatedAt:     dbUser.CreatedAt,
+		UpdatedAt:     dbUser.UpdatedAt,
+		Email:         dbUser.Email,
+		IsChirpyRed:   dbUser.IsChirpyRed,
+		Handle:        dbUser.Handle,
+		DisplayName:   dbUser.DisplayName,
+		Bio:           dbUser.Bio,
+		AvatarURL:     dbUser.AvatarUrl,
+		EmailVerified: dbUser.EmailVerifiedAt.Valid,
+		Role:          dbUser.Role,
+	}
+}
+
+// outputProfile is the public view of a user. Email and Chirpy Red status are
+// only filled in when users look at their own profile.
+type outputProfile struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	Handle         string    `json:"handle"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	ChirpCount     int64     `json:"chirp_count"`
+	FollowerCount  int64     `json:"follower_count"`
+	FollowingCount int64     `json:"following_count"`
+	Email          *string   `json:"email,omitempty"`
+	IsChirpyRed    *bool     `json:"is_chirpy_red,omitempty"`
+}
+
+type CredentialsRequestBody struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type ProfileRequestBody struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+type CreateUserRequestBody struct {
+	CredentialsRequestBody
+	ProfileRequestBody
+}
+
+type UpdateUserRequestBody = CreateUserRequestBody
+
+func (body ProfileRequestBody) IsEmpty() bool {
+	return body.Handle == nil && body.DisplayName == nil && body.Bio == nil && body.AvatarURL == nil
+}
+
+func (body ProfileRequestBody) Validate() error {
+	if body.Handle != nil {
+		if err := chirptext.ValidateHandle(*body.Handle); err != nil {
+			return err
+		}
+	}
+	if body.DisplayName != nil && utf8.RuneCountInString(*body.DisplayName) > maxDisplayNameLength {
+		return errors.New("Display name too long")
+	}
+	if body.Bio != nil && utf8.RuneCountInString(*body.Bio) > maxBioLength {
+		return errors.New("Bio too long")
+	}
+	if body.AvatarURL != nil && *body.AvatarURL != "" {
+		if len(*body.AvatarURL) > maxAvatarURLLength {
+			return errors.New("Avatar URL too long")
+		}
+		parsed, err := url.Parse(*body.AvatarURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.New("Avatar URL must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+func valueOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(*value)
+}
+
+func nullString(value *string) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strings.TrimSpace(*value), Valid: true}
+}
+
+// passwordUserInputs lists what an attacker knows about the user and would try
+// first when guessing their password.
+func passwordUserInputs(email string, others ...string) []string {
+	local, _, _ := strings.Cut(email, "@")
+	inputs := []string{email, local}
+	for _, other := range others {
+		if other != "" {
+			inputs = append(inputs, other)
+		}
+	}
+	return inputs
+}
+
+// generatedHandle is given to users that do not pick a handle on sign up.
+func generatedHandle() string {
+	return "user_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+}
+
 func (cfg *ApiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 	var body CreateUserRequestBody
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -35,17 +160,47 @@ func (cfg *ApiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 		BadRequestResponse(w, "Email must not be empty.")
 		return
 	}
+	if err := validateEmail(body.Email); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
 
 	if body.Password == "" {
 		BadRequestResponse(w, "Password must not be empty.")
 		return
 	}
+	if err := body.ProfileRequestBody.Validate(); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	if err := cfg.PasswordPolicy.Check(r.Context(), body.Password, passwordUserInputs(body.Email, valueOrEmpty(body.Handle), valueOrEmpty(body.DisplayName))...); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
+	handle := valueOrEmpty(body.Handle)
+	if handle == "" {
+		handle = generatedHandle()
+	}
+	hashedPassword, err := cfg.Passwords.Hash(body.Password)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error. Contact administrators")
+		return
+	}
 	dbUser, err := cfg.DB.CreateUser(r.Context(), database.CreateUserParams{
 		Email:          body.Email,
-		HashedPassword: auth.HashPassword(body.Password),
+		HashedPassword: hashedPassword,
+		Handle:         handle,
+		DisplayName:    valueOrEmpty(body.DisplayName),
+		Bio:            valueOrEmpty(body.Bio),
+		AvatarUrl:      valueOrEmpty(body.AvatarURL),
 	})
 	if err != nil {
-		if err, ok := err.(*pq.Error); !ok || err.Constraint == "users_email_key" {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Constraint == usersHandleConstraint {
+			RespondWithError(w, http.StatusConflict, "Handle already taken.")
+			return
+		}
+		if !ok || pqErr.Constraint == usersEmailConstraint {
 			RespondWithError(w, http.StatusConflict, "User already exists.")
 			return
 		}
@@ -53,50 +208,165 @@ func (cfg *ApiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 		InternalServerErrorResponse(w, "Unexpected error. Contact administrators")
 		return
 	}
-	RespondWithJSON(w, http.StatusCreated, User{
-		ID:          dbUser.ID,
-		CreatedAt:   dbUser.CreatedAt,
-		UpdatedAt:   dbUser.UpdatedAt,
-		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
-	})
+	if err := cfg.sendVerificationEmail(dbUser); err != nil {
+		log.Printf("Could not send verification email: %s\n", err)
+	}
+	RespondWithJSON(w, http.StatusCreated, newUser(dbUser))
 }
 
+// updateUser changes the credentials, the profile or both. Credentials are
+// only updated when both email and password are sent.
 func (api *ApiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 	userID := parseUserIDFromRequest(r)
-	type input = CreateUserRequestBody
-	var body input
+	var body UpdateUserRequestBody
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		InternalServerErrorResponse(w, "Request body is not valid JSON.")
 		return
 	}
-	if body.Email == "" {
+	updateCredentials := body.Email != "" || body.Password != "" || body.ProfileRequestBody.IsEmpty()
+	if _, personalToken := personalTokenScopes(r); updateCredentials && personalToken {
+		ForbiddenResponse(w, "Personal access tokens can only update the profile. Log in to change your credentials.")
+		return
+	}
+	if updateCredentials && body.Email == "" {
 		BadRequestResponse(w, "Email must not be empty.")
 		return
 	}
-	if body.Password == "" {
+	if updateCredentials && body.Password == "" {
 		BadRequestResponse(w, "Password must not be empty.")
 		return
 	}
-	hashedPassword := auth.HashPassword(body.Password)
+	if updateCredentials {
+		if err := validateEmail(body.Email); err != nil {
+			BadRequestResponse(w, err.Error())
+			return
+		}
+		if err := api.PasswordPolicy.Check(r.Context(), body.Password, passwordUserInputs(body.Email, valueOrEmpty(body.Handle), valueOrEmpty(body.DisplayName))...); err != nil {
+			BadRequestResponse(w, err.Error())
+			return
+		}
+	}
+	if err := body.ProfileRequestBody.Validate(); err != nil {
+		BadRequestResponse(w, err.Error())
+		return
+	}
 
-	user, err := api.DB.UpdateUserCredentials(r.Context(), database.UpdateUserCredentialsParams{
-		ID:             userID,
-		Email:          body.Email,
-		HashedPassword: hashedPassword,
+	var hashedPassword string
+	if updateCredentials {
+		var err error
+		if hashedPassword, err = api.Passwords.Hash(body.Password); err != nil {
+			InternalServerErrorResponse(w, "Could not update user. Try again later")
+			return
+		}
+	}
+	var user database.User
+	err := api.inTx(r.Context(), func(queries *database.Queries) error {
+		var err error
+		if updateCredentials {
+			user, err = queries.UpdateUserCredentials(r.Context(), database.UpdateUserCredentialsParams{
+				ID:             userID,
+				Email:          body.Email,
+				HashedPassword: hashedPassword,
+			})
+			if err != nil {
+				return err
+			}
+			// New credentials bump the token version, which signs the user
+			// out everywhere. Refresh tokens have to go as well or they could
+			// mint tokens for the new version, and so do personal access
+			// tokens, which do not carry a version.
+			if err := queries.RevokeAllSessions(r.Context(), userID); err != nil {
+				return err
+			}
+			if err := queries.DeleteUserPersonalAccessTokens(r.Context(), userID); err != nil {
+				return err
+			}
+		}
+		if !body.ProfileRequestBody.IsEmpty() {
+			user, err = queries.UpdateUserProfile(r.Context(), database.UpdateUserProfileParams{
+				ID:          userID,
+				Handle:      nullString(body.Handle),
+				DisplayName: nullString(body.DisplayName),
+				Bio:         nullString(body.Bio),
+				AvatarUrl:   nullString(body.AvatarURL),
+			})
+		}
+		return err
 	})
 	if err != nil {
 		pqErr, ok := err.(*pq.Error)
-		if ok && pqErr.Code.Name() == "unique_violation" {
+		if ok && pqErr.Constraint == usersHandleConstraint {
+			BadRequestResponse(w, "Handle already taken")
+		} else if ok && pqErr.Code.Name() == "unique_violation" {
 			BadRequestResponse(w, "Email already taken")
+		} else {
+			InternalServerErrorResponse(w, "Could not update user. Try again later")
 		}
 		return
 	}
-	OkResponse(w, User{
-		ID:          user.ID,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	if updateCredentials {
+		api.tokenVersions.set(userID, user.TokenVersion)
+		if !user.EmailVerifiedAt.Valid {
+			// The new credentials are saved already: a failed email must not
+			// turn that into an error. The user can ask for it again.
+			if err := api.sendVerificationEmail(user); err != nil {
+				slog.Error("Could not send verification email", "user_id", userID, "error", err)
+			}
+		}
+	}
+	OkResponse(w, newUser(user))
+}
+
+// getProfile looks a user up by handle, by id or, with "me", by the access
+// token of the request.
+func (api *ApiConfig) getProfile(w http.ResponseWriter, r *http.Request) {
+	viewerID := api.viewerIDFromRequest(r)
+	handleOrID := r.PathValue("handleOrID")
+
+	var user database.User
+	var err error
+	if handleOrID == "me" {
+		if !viewerID.Valid {
+			UnauthorizedResponse(w, "No credentials provided")
+			return
+		}
+		user, err = api.DB.GetUserByID(r.Context(), viewerID.UUID)
+	} else if userID, parseErr := uuid.Parse(handleOrID); parseErr == nil {
+		user, err = api.DB.GetUserByID(r.Context(), userID)
+	} else {
+		user, err = api.DB.GetUserByHandle(r.Context(), handleOrID)
+	}
+	if err == nil && user.DeletedAt.Valid {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			NotFoundResponse(w, "User not found")
+		} else {
+			InternalServerErrorResponse(w, "Unexpected error")
+		}
+		return
+	}
+
+	stats, err := api.DB.GetUserStats(r.Context(), user.ID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Unexpected error")
+		return
+	}
+	output := outputProfile{
+		ID:             user.ID,
+		CreatedAt:      user.CreatedAt,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		AvatarURL:      user.AvatarUrl,
+		ChirpCount:     stats.ChirpCount,
+		FollowerCount:  stats.FollowerCount,
+		FollowingCount: stats.FollowingCount,
+	}
+	if viewerID.Valid && viewerID.UUID == user.ID {
+		output.Email = &user.Email
+		output.IsChirpyRed = &user.IsChirpyRed
+	}
+	OkResponse(w, output)
 }
@@ -1,65 +1,112 @@
 package api
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"io"
+	"log/slog"
 	"net/http"
+	"time"
 
-	"github.com/JP-Go/http-server-go/internal/auth"
 	"github.com/JP-Go/http-server-go/internal/database"
 	"github.com/google/uuid"
 )
 
 const polkaUserUpgradedEvent = "user.upgraded"
 
+const polkaTimestampHeader = "X-Polka-Timestamp"
+const polkaSignatureHeader = "X-Polka-Signature"
+const maxWebhookBodyBytes = 64 << 10
+
+// webhookEventRetention is how long event IDs are remembered. Deliveries
+// older than the signature tolerance are refused anyway, so it only has to
+// outlast the tolerance window on both sides of our clock.
+const webhookEventRetention = 24 * time.Hour
+
 type PolkaWebhookEvent struct {
+	ID    string `json:"id"`
 	Event string `json:"event"`
 	Data  struct {
 		UserID string `json:"user_id"`
 	} `json:"data"`
 }
 
+// polkaUpgradeToChirpyRed handles Polka deliveries. They are signed over the
+// raw body, so the body is read whole and verified before it is parsed. Each
+// event ID is only processed once.
 func (api *ApiConfig) polkaUpgradeToChirpyRed(w http.ResponseWriter, r *http.Request) {
-	providedKey, err := auth.GetAPIKey(r.Header)
+	rawBody, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
 	if err != nil {
-		UnauthorizedResponse(w, err.Error())
+		BadRequestResponse(w, "Could not read body")
 		return
 	}
-	if providedKey != api.PolkaApiKey {
-		UnauthorizedResponse(w, "Invalid Polka API Key")
+	_, err = api.PolkaWebhooks.Verify(rawBody, r.Header.Get(polkaTimestampHeader), r.Header.Get(polkaSignatureHeader), time.Now())
+	if err != nil {
+		logSecurityEvent("webhook_signature_rejected", "reason", err.Error(), "ip", clientIP(r))
+		UnauthorizedResponse(w, err.Error())
+		return
 	}
 	var body PolkaWebhookEvent
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&body); err != nil {
+	if err := json.NewDecoder(bytes.NewReader(rawBody)).Decode(&body); err != nil {
 		BadRequestResponse(w, "Invalid JSON body")
 		return
 	}
+	if body.ID == "" {
+		BadRequestResponse(w, "Missing event ID")
+		return
+	}
+	recorded, err := api.DB.RecordWebhookEvent(r.Context(), body.ID)
+	if err != nil {
+		InternalServerErrorResponse(w, "Could not process event. Try again later.")
+		return
+	}
+	if recorded == 0 {
+		logSecurityEvent("webhook_replay", "event_id", body.ID, "ip", clientIP(r))
+		RespondWithError(w, http.StatusConflict, "Event already processed")
+		return
+	}
+	if err := api.DB.DeleteOldWebhookEvents(r.Context(), time.Now().UTC().Add(-webhookEventRetention)); err != nil {
+		slog.Error("Could not delete old webhook events", "error", err)
+	}
+	if !api.handlePolkaEvent(w, r, body) {
+		// Let Polka retry an event we could not process.
+		if err := api.DB.ForgetWebhookEvent(r.Context(), body.ID); err != nil {
+			slog.Error("Could not forget webhook event", "event_id", body.ID, "error", err)
+		}
+	}
+}
+
+// handlePolkaEvent answers the delivery and reports whether the event was
+// dealt with for good.
+func (api *ApiConfig) handlePolkaEvent(w http.ResponseWriter, r *http.Request, body PolkaWebhookEvent) bool {
 	if body.Event != polkaUserUpgradedEvent {
 		RespondWithJSON(w, http.StatusNoContent, struct{}{})
-		return
+		return true
 	}
 	userID, err := uuid.Parse(body.Data.UserID)
 	if err != nil {
 		BadRequestResponse(w, "Invalid user ID")
+		return true
 	}
 	user, err := api.DB.GetUserByID(r.Context(), userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			NotFoundResponse(w, "User not found")
-		} else {
-			InternalServerErrorResponse(w, err.Error())
+			return true
 		}
-		return
+		InternalServerErrorResponse(w, "Unexpected error. Try again later.")
+		return false
 	}
-	user, err = api.DB.UpgradeChirpyRed(r.Context(), database.UpgradeChirpyRedParams{
+	_, err = api.DB.UpgradeChirpyRed(r.Context(), database.UpgradeChirpyRedParams{
 		ID:          user.ID,
 		IsChirpyRed: true,
 	})
 	if err != nil {
 		InternalServerErrorResponse(w, "Could not upgrade user account. Try again later.")
-		return
+		return false
 	}
 	RespondWithJSON(w, http.StatusNoContent, struct{}{})
-
+	return true
 }
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const mfaAudience = "chirpy-mfa"
+
+// MFAChallenge is handed out by a login with a correct password when the user
+// still has to provide a second factor.
+type MFAChallenge struct {
+	ID     string
+	UserID uuid.UUID
+}
+
+func (k *KeyRing) MakeMFAChallenge(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	return k.sign(jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Issuer:    DefaultIssuer,
+		Subject:   userID.String(),
+		Audience:  jwt.ClaimStrings{mfaAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+	})
+}
+
+func (k *KeyRing) ValidateMFAChallenge(tokenString string) (MFAChallenge, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc,
+		jwt.WithIssuer(DefaultIssuer), jwt.WithAudience(mfaAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+	if !token.Valid {
+		return MFAChallenge{}, errors.New("Invalid token")
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+	return MFAChallenge{ID: claims.ID, UserID: userID}, nil
+}
@@ -0,0 +1,64 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/google/uuid"
+)
+
+func Test_MFAChallengeRoundTrip(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	userID := uuid.New()
+	signed, err := ring.MakeMFAChallenge(userID, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not sign challenge: %s", err)
+	}
+	challenge, err := ring.ValidateMFAChallenge(signed)
+	if err != nil || challenge.UserID != userID {
+		t.Errorf("ValidateMFAChallenge = %v, %v, expected %v", challenge.UserID, err, userID)
+	}
+}
+
+func Test_MFAChallengeIsNotAnAccessToken(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	challenge, _ := ring.MakeMFAChallenge(uuid.New(), time.Minute)
+	if _, err := ring.ParseAccessToken(challenge); err == nil {
+		t.Error("An MFA challenge should not be accepted as an access token")
+	}
+	access, _ := ring.MakeJWT(uuid.New(), uuid.New(), 0, auth.RoleUser, time.Minute)
+	if _, err := ring.ValidateMFAChallenge(access); err == nil {
+		t.Error("An access token should not be accepted as an MFA challenge")
+	}
+}
+
+func Test_MFAChallengeExpires(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	challenge, _ := ring.MakeMFAChallenge(uuid.New(), -time.Minute)
+	if _, err := ring.ValidateMFAChallenge(challenge); err == nil {
+		t.Error("An expired MFA challenge should be rejected")
+	}
+}
+
+func Test_EmailTokenIsBoundToItsPurpose(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	userID := uuid.New()
+	signed, err := ring.MakeEmailToken(auth.PurposeVerifyEmail, userID, "jo@example.com", 2, time.Hour)
+	if err != nil {
+		t.Fatalf("Could not sign email token: %s", err)
+	}
+	token, err := ring.ValidateEmailToken(auth.PurposeVerifyEmail, signed)
+	if err != nil {
+		t.Fatalf("ValidateEmailToken should not error: %s", err)
+	}
+	if token.UserID != userID || token.Email != "jo@example.com" || token.TokenVersion != 2 {
+		t.Errorf("Unexpected token claims %+v", token)
+	}
+	if _, err := ring.ValidateEmailToken(auth.PurposeResetPassword, signed); err == nil {
+		t.Error("A verification token should not reset a password")
+	}
+	if _, err := ring.ParseAccessToken(signed); err == nil {
+		t.Error("An email token should not be accepted as an access token")
+	}
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Purposes of the tokens we send by email. The purpose is the audience of
+// the token, so a token minted for one flow is rejected by every other.
+const (
+	PurposeVerifyEmail   = "verify-email"
+	PurposeResetPassword = "reset-password"
+)
+
+// EmailToken proves control of a mailbox. Verification tokens are bound to
+// the address they were sent to and reset tokens to the token version of the
+// user, which changes with the password. Callers make them single use by
+// remembering the ID.
+type EmailToken struct {
+	ID           string
+	UserID       uuid.UUID
+	Email        string
+	TokenVersion int32
+	ExpiresAt    time.Time
+}
+
+type emailClaims struct {
+	jwt.RegisteredClaims
+	Email        string `json:"email"`
+	TokenVersion int32  `json:"ver"`
+}
+
+func (k *KeyRing) MakeEmailToken(purpose string, userID uuid.UUID, email string, tokenVersion int32, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	return k.sign(emailClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    DefaultIssuer,
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{"chirpy-" + purpose},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		},
+		Email:        email,
+		TokenVersion: tokenVersion,
+	})
+}
+
+func (k *KeyRing) ValidateEmailToken(purpose, tokenString string) (EmailToken, error) {
+	claims := &emailClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc,
+		jwt.WithIssuer(DefaultIssuer), jwt.WithAudience("chirpy-"+purpose), jwt.WithExpirationRequired())
+	if err != nil {
+		return EmailToken{}, err
+	}
+	if !token.Valid {
+		return EmailToken{}, errors.New("Invalid token")
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return EmailToken{}, err
+	}
+	if _, err := uuid.Parse(claims.ID); err != nil {
+		return EmailToken{}, errors.New("Token has no valid ID")
+	}
+	return EmailToken{
+		ID:           claims.ID,
+		UserID:       userID,
+		Email:        claims.Email,
+		TokenVersion: claims.TokenVersion,
+		ExpiresAt:    claims.ExpiresAt.Time,
+	}, nil
+}
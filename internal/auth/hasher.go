@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("Password does not match")
+
+// Argon2Params are the cost parameters of argon2id. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the second recommendation of RFC 9106.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// DefaultMaxConcurrentHashes bounds how many hashes run at once. With the
+// default parameters every argon2id hash holds 64 MiB while it runs.
+var DefaultMaxConcurrentHashes = runtime.NumCPU()
+
+// PasswordHasher hashes new passwords with one algorithm and verifies hashes
+// of every supported algorithm. The algorithm and its parameters are encoded
+// in the hash itself: argon2id hashes use the PHC string format and bcrypt
+// hashes the usual $2a$ format, so stored hashes can be migrated one login at
+// a time.
+type PasswordHasher struct {
+	algorithm  string
+	argon2     Argon2Params
+	bcryptCost int
+	// slots holds one token per hash that is running. Requests past the
+	// limit wait instead of allocating more memory.
+	slots chan struct{}
+	// dummies are hashes of every supported algorithm that VerifyUniform
+	// checks passwords against.
+	dummies func() map[string]string
+}
+
+func NewArgon2Hasher(params Argon2Params) *PasswordHasher {
+	return newPasswordHasher(AlgorithmArgon2id, params, bcrypt.DefaultCost)
+}
+
+func NewBcryptHasher(cost int) *PasswordHasher {
+	return newPasswordHasher(AlgorithmBcrypt, DefaultArgon2Params, cost)
+}
+
+func newPasswordHasher(algorithm string, params Argon2Params, bcryptCost int) *PasswordHasher {
+	h := &PasswordHasher{
+		algorithm:  algorithm,
+		argon2:     params,
+		bcryptCost: bcryptCost,
+		slots:      make(chan struct{}, max(DefaultMaxConcurrentHashes, 1)),
+	}
+	h.dummies = sync.OnceValue(func() map[string]string {
+		dummies := map[string]string{}
+		for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+			dummies[algorithm], _ = h.hash(algorithm, "not the password of anyone")
+		}
+		return dummies
+	})
+	return h
+}
+
+// WithMaxConcurrency replaces DefaultMaxConcurrentHashes for this hasher. It
+// must be called before the hasher is used.
+func (h *PasswordHasher) WithMaxConcurrency(n int) *PasswordHasher {
+	h.slots = make(chan struct{}, max(n, 1))
+	return h
+}
+
+func (h *PasswordHasher) acquire() func() {
+	h.slots <- struct{}{}
+	return func() { <-h.slots }
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	defer h.acquire()()
+	return h.hash(h.algorithm, password)
+}
+
+func (h *PasswordHasher) hash(algorithm, password string) (string, error) {
+	if algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+	salt := make([]byte, h.argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.argon2.Iterations, h.argon2.Memory, h.argon2.Parallelism, h.argon2.KeyLength)
+	return encodeArgon2(h.argon2, salt, key), nil
+}
+
+// Verify checks password against a hash of any supported algorithm.
+func (h *PasswordHasher) Verify(password, encoded string) error {
+	defer h.acquire()()
+	return h.verify(password, encoded)
+}
+
+// VerifyUniform checks password like Verify and then against a dummy hash of
+// every other supported algorithm, so that the time it takes does not tell
+// which algorithm encoded was made with, or whether there was a hash at all.
+// An empty encoded is only checked against the dummies and never matches.
+func (h *PasswordHasher) VerifyUniform(password, encoded string) error {
+	defer h.acquire()()
+	err := errors.New("No password hash")
+	if encoded != "" {
+		err = h.verify(password, encoded)
+	}
+	for algorithm, dummy := range h.dummies() {
+		if algorithm != algorithmOf(encoded) {
+			h.verify(password, dummy)
+		}
+	}
+	return err
+}
+
+func (h *PasswordHasher) verify(password, encoded string) error {
+	switch algorithmOf(encoded) {
+	case AlgorithmArgon2id:
+		params, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return err
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(candidate, key) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	case AlgorithmBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return fmt.Errorf("%w: %w", ErrPasswordMismatch, err)
+		}
+		return err
+	default:
+		return errors.New("Unknown password hash format")
+	}
+}
+
+// NeedsRehash reports whether encoded was made with another algorithm or
+// other parameters than the ones the hasher uses now.
+func (h *PasswordHasher) NeedsRehash(encoded string) bool {
+	if algorithmOf(encoded) != h.algorithm {
+		return true
+	}
+	if h.algorithm == AlgorithmBcrypt {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.bcryptCost
+	}
+	params, salt, _, err := decodeArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	params.SaltLength = uint32(len(salt))
+	return params != h.argon2
+}
+
+func algorithmOf(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return ""
+	}
+}
+
+func encodeArgon2(params Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, errors.New("Invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, errors.New("Unsupported argon2 version")
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, errors.New("Invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,98 @@
+package auth_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2Params keep the tests fast.
+var testArgon2Params = auth.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func Test_Argon2HasherRoundTrip(t *testing.T) {
+	hasher := auth.NewArgon2Hasher(testArgon2Params)
+	hash, err := hasher.Hash("tunnel-cactus-violin-58")
+	if err != nil {
+		t.Fatalf("Hash should not error: %s", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Unexpected hash format %q", hash)
+	}
+	if err := hasher.Verify("tunnel-cactus-violin-58", hash); err != nil {
+		t.Errorf("Verify should accept the password: %s", err)
+	}
+	if err := hasher.Verify("tunnel-cactus-violin-59", hash); !errors.Is(err, auth.ErrPasswordMismatch) {
+		t.Errorf("Verify = %v, expected ErrPasswordMismatch", err)
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("A hash made with the current parameters does not need a rehash")
+	}
+}
+
+func Test_HasherVerifiesAndUpgradesBcryptHashes(t *testing.T) {
+	hasher := auth.NewArgon2Hasher(testArgon2Params)
+	hashed, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Could not hash password: %s", err)
+	}
+	legacy := string(hashed)
+	if err := hasher.Verify("pass", legacy); err != nil {
+		t.Errorf("Verify should accept bcrypt hashes: %s", err)
+	}
+	err = hasher.Verify("other", legacy)
+	if !errors.Is(err, auth.ErrPasswordMismatch) || !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		t.Errorf("Verify = %v, expected a mismatch", err)
+	}
+	if !hasher.NeedsRehash(legacy) {
+		t.Error("bcrypt hashes should be upgraded to argon2id")
+	}
+}
+
+func Test_HasherHandlesPasswordsTooLongForBcrypt(t *testing.T) {
+	password := "A very long password that has more than 72 characters. It is longer than the 72 characters that bcrypt uses."
+	if _, err := auth.NewBcryptHasher(bcrypt.MinCost).Hash(password); !errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		t.Errorf("bcrypt Hash(%q) error = %v, expected %v", password, err, bcrypt.ErrPasswordTooLong)
+	}
+	hasher := auth.NewArgon2Hasher(testArgon2Params)
+	hash, err := hasher.Hash(password)
+	if err != nil {
+		t.Fatalf("argon2id Hash should not error: %s", err)
+	}
+	if err := hasher.Verify(password[:72], hash); !errors.Is(err, auth.ErrPasswordMismatch) {
+		t.Errorf("Verify = %v, expected every byte of the password to count", err)
+	}
+}
+
+func Test_HasherNeedsRehashWhenParametersChange(t *testing.T) {
+	old := auth.NewArgon2Hasher(testArgon2Params)
+	hash, _ := old.Hash("pass")
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	if !auth.NewArgon2Hasher(stronger).NeedsRehash(hash) {
+		t.Error("A hash made with weaker parameters should be rehashed")
+	}
+	if err := auth.NewArgon2Hasher(stronger).Verify("pass", hash); err != nil {
+		t.Errorf("Verify should use the parameters stored in the hash: %s", err)
+	}
+	bcryptHasher := auth.NewBcryptHasher(bcrypt.MinCost)
+	if !bcryptHasher.NeedsRehash(hash) {
+		t.Error("Switching back to bcrypt should rehash argon2id hashes")
+	}
+}
+
+func Test_HasherVerifyUniform(t *testing.T) {
+	hasher := auth.NewArgon2Hasher(testArgon2Params)
+	hash, _ := hasher.Hash("pass")
+	if err := hasher.VerifyUniform("pass", hash); err != nil {
+		t.Errorf("VerifyUniform should accept the password: %s", err)
+	}
+	if err := hasher.VerifyUniform("other", hash); !errors.Is(err, auth.ErrPasswordMismatch) {
+		t.Errorf("VerifyUniform = %v, expected ErrPasswordMismatch", err)
+	}
+	if err := hasher.VerifyUniform("not the password of anyone", ""); err == nil {
+		t.Error("VerifyUniform should reject a missing hash, even with the dummy password")
+	}
+}
@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const DefaultIssuer = "chirpy"
+
+// SigningKey is one entry of a KeyRing. Symmetric keys are never published in
+// the JWKS document.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Retired   bool
+	signKey   any
+	verifyKey any
+}
+
+// KeyRing signs tokens with its active key and verifies tokens signed by any
+// key that has not been retired, which lets keys be rotated without logging
+// everyone out.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewHMACKey(kid, secret string) SigningKey {
+	return SigningKey{ID: kid, Method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+}
+
+func NewRSAKey(kid string, key *rsa.PrivateKey) SigningKey {
+	return SigningKey{ID: kid, Method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}
+}
+
+func NewEd25519Key(kid string, key ed25519.PrivateKey) SigningKey {
+	return SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, signKey: key, verifyKey: key.Public()}
+}
+
+// ParsePrivateKeyPEM reads an RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8)
+// private key.
+func ParsePrivateKeyPEM(kid string, data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New("No PEM block found")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		return NewRSAKey(kid, key), nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return NewRSAKey(kid, key), nil
+	case ed25519.PrivateKey:
+		return NewEd25519Key(kid, key), nil
+	default:
+		return SigningKey{}, fmt.Errorf("Unsupported key type %T", key)
+	}
+}
+
+func NewKeyRing(activeKid string, keys ...SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string]*SigningKey, len(keys))}
+	for _, key := range keys {
+		if _, ok := ring.keys[key.ID]; ok {
+			return nil, fmt.Errorf("Duplicated key id %q", key.ID)
+		}
+		ring.keys[key.ID] = &key
+	}
+	active, ok := ring.keys[activeKid]
+	if !ok {
+		return nil, fmt.Errorf("Active key %q not found", activeKid)
+	}
+	if active.Retired {
+		return nil, fmt.Errorf("Active key %q is retired", activeKid)
+	}
+	ring.active = active
+	return ring, nil
+}
+
+// NewHMACKeyRing keeps the historical single shared secret setup.
+func NewHMACKeyRing(secret string) *KeyRing {
+	ring, _ := NewKeyRing("", NewHMACKey("", secret))
+	return ring
+}
+
+// LoadKeyRing reads every <kid>.pem file of dir. Keys listed in retired stay
+// loaded but no longer sign nor verify tokens.
+func LoadKeyRing(dir, activeKid string, retired []string) (*KeyRing, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := ParsePrivateKeyPEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load key %s: %w", path, err)
+		}
+		key.Retired = slices.Contains(retired, kid)
+		keys = append(keys, key)
+	}
+	return NewKeyRing(activeKid, keys...)
+}
+
+// accessClaims are the claims of our access tokens. The token version is
+// compared against the user's current version so that every token issued
+// before a password change or a "log out everywhere" can be rejected. Role
+// changes bump the version too, so the role claim is never stale. The session
+// is the refresh token family the token was issued for, so that ending one
+// session rejects its access tokens as well.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	SessionID    string `json:"sid"`
+	TokenVersion int32  `json:"ver"`
+	Role         string `json:"role,omitempty"`
+}
+
+// MakeJWT issues an access token for a session and the given version of the
+// user's tokens.
+func (k *KeyRing) MakeJWT(userID, sessionID uuid.UUID, tokenVersion int32, role string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	return k.sign(accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    DefaultIssuer,
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		},
+		SessionID:    sessionID.String(),
+		TokenVersion: tokenVersion,
+		Role:         role,
+	})
+}
+
+func (k *KeyRing) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	if k.active.ID != "" {
+		token.Header["kid"] = k.active.ID
+	}
+	return token.SignedString(k.active.signKey)
+}
+
+// AccessToken holds the claims of a validated access token.
+type AccessToken struct {
+	UserID       uuid.UUID
+	SessionID    uuid.UUID
+	IssuedAt     time.Time
+	TokenVersion int32
+	Role         string
+}
+
+func (k *KeyRing) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	token, err := k.ParseAccessToken(tokenString)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return token.UserID, nil
+}
+
+// ParseAccessToken validates an access token and returns its claims.
+func (k *KeyRing) ParseAccessToken(tokenString string) (AccessToken, error) {
+	claims := &accessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc, jwt.WithIssuer(DefaultIssuer), jwt.WithIssuedAt())
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if !token.Valid {
+		return AccessToken{}, errors.New("Invalid token")
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if claims.IssuedAt == nil {
+		return AccessToken{}, errors.New("Token has no issued at")
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return AccessToken{}, errors.New("Token has no session")
+	}
+	// Access tokens have no audience. Anything else, like an MFA challenge,
+	// must not be usable in their place.
+	if len(claims.Audience) > 0 {
+		return AccessToken{}, errors.New("Not an access token")
+	}
+	role := claims.Role
+	if role == "" {
+		// Tokens issued before roles existed belong to regular users.
+		role = RoleUser
+	}
+	return AccessToken{
+		UserID:       userID,
+		SessionID:    sessionID,
+		IssuedAt:     claims.IssuedAt.Time,
+		TokenVersion: claims.TokenVersion,
+		Role:         role,
+	}, nil
+}
+
+// keyFunc picks the verification key from the kid header and makes sure the
+// token uses the algorithm of that key, so a public key can never be used as
+// an HMAC secret.
+func (k *KeyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok || key.Retired {
+		return nil, errors.New("Unknown signing key")
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("Unexpected signing method")
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS lists the public keys other services need to verify our tokens.
+func (k *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		key := k.keys[id]
+		if key.Retired {
+			continue
+		}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
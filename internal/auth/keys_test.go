@@ -0,0 +1,170 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestKeys(t *testing.T) (auth.SigningKey, auth.SigningKey) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Could not generate RSA key: %s", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate Ed25519 key: %s", err)
+	}
+	return auth.NewRSAKey("rsa-1", rsaKey), auth.NewEd25519Key("ed-1", edKey)
+}
+
+func Test_KeyRingSignsWithActiveKey(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	for _, active := range []auth.SigningKey{rsaKey, edKey} {
+		ring, err := auth.NewKeyRing(active.ID, rsaKey, edKey)
+		if err != nil {
+			t.Fatalf("NewKeyRing should not error: %s", err)
+		}
+		userID := uuid.New()
+		signed, err := ring.MakeJWT(userID, uuid.New(), 0, auth.RoleUser, time.Minute)
+		if err != nil {
+			t.Fatalf("Could not sign token with %s: %s", active.ID, err)
+		}
+		token, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatalf("Could not parse token: %s", err)
+		}
+		if token.Header["kid"] != active.ID || token.Method.Alg() != active.Method.Alg() {
+			t.Errorf("Token header = %v, expected kid %s and alg %s", token.Header, active.ID, active.Method.Alg())
+		}
+		possibleUserID, err := ring.ValidateJWT(signed)
+		if err != nil || possibleUserID != userID {
+			t.Errorf("ValidateJWT = %v, %v, expected %v", possibleUserID, err, userID)
+		}
+	}
+}
+
+func Test_ParseAccessTokenReturnsClaims(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	userID, sessionID := uuid.New(), uuid.New()
+	before := time.Now().Truncate(time.Second)
+	signed, err := ring.MakeJWT(userID, sessionID, 3, auth.RoleModerator, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not sign token: %s", err)
+	}
+	token, err := ring.ParseAccessToken(signed)
+	if err != nil {
+		t.Fatalf("ParseAccessToken should not error: %s", err)
+	}
+	if token.UserID != userID {
+		t.Errorf("UserID = %v, expected %v", token.UserID, userID)
+	}
+	if token.SessionID != sessionID {
+		t.Errorf("SessionID = %v, expected %v", token.SessionID, sessionID)
+	}
+	if token.TokenVersion != 3 {
+		t.Errorf("TokenVersion = %d, expected 3", token.TokenVersion)
+	}
+	if token.Role != auth.RoleModerator {
+		t.Errorf("Role = %s, expected %s", token.Role, auth.RoleModerator)
+	}
+	if token.IssuedAt.Before(before) || token.IssuedAt.After(time.Now()) {
+		t.Errorf("IssuedAt = %v, expected a time after %v", token.IssuedAt, before)
+	}
+}
+
+func Test_KeyRingAcceptsTokensFromPreviousKeyDuringRotation(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	oldRing, _ := auth.NewKeyRing(rsaKey.ID, rsaKey)
+	signed, err := oldRing.MakeJWT(uuid.New(), uuid.New(), 0, auth.RoleUser, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not sign token: %s", err)
+	}
+
+	rotated, _ := auth.NewKeyRing(edKey.ID, rsaKey, edKey)
+	if _, err := rotated.ValidateJWT(signed); err != nil {
+		t.Errorf("Token signed by a previous key should validate: %s", err)
+	}
+
+	rsaKey.Retired = true
+	retired, _ := auth.NewKeyRing(edKey.ID, rsaKey, edKey)
+	if _, err := retired.ValidateJWT(signed); err == nil {
+		t.Error("Token signed by a retired key should not validate")
+	}
+	if len(retired.JWKS().Keys) != 1 {
+		t.Errorf("Retired keys should not be published, got %+v", retired.JWKS())
+	}
+}
+
+func Test_KeyRingRejectsExpiredTokens(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	signed, err := ring.MakeJWT(uuid.New(), uuid.New(), 0, auth.RoleUser, -time.Minute)
+	if err != nil {
+		t.Fatalf("Could not sign token: %s", err)
+	}
+	if _, err := ring.ValidateJWT(signed); err == nil {
+		t.Error("An expired token should not validate")
+	}
+}
+
+func Test_KeyRingRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := newTestKeys(t)
+	ring, _ := auth.NewKeyRing(rsaKey.ID, rsaKey)
+	hmacRing, _ := auth.NewKeyRing(rsaKey.ID, auth.NewHMACKey(rsaKey.ID, "secret"))
+	signed, err := hmacRing.MakeJWT(uuid.New(), uuid.New(), 0, auth.RoleUser, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not sign token: %s", err)
+	}
+	if _, err := ring.ValidateJWT(signed); err == nil {
+		t.Error("HS256 token should not validate against an RS256 key")
+	}
+}
+
+func Test_JWKSDoesNotPublishSymmetricKeys(t *testing.T) {
+	rsaKey, edKey := newTestKeys(t)
+	ring, _ := auth.NewKeyRing("hmac", rsaKey, edKey, auth.NewHMACKey("hmac", "secret"))
+	set := ring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS should contain 2 keys, got %+v", set)
+	}
+	if set.Keys[0].KeyID != "ed-1" || set.Keys[0].KeyType != "OKP" || set.Keys[0].X == "" {
+		t.Errorf("Unexpected Ed25519 JWK %+v", set.Keys[0])
+	}
+	if set.Keys[1].KeyID != "rsa-1" || set.Keys[1].KeyType != "RSA" || set.Keys[1].E != "AQAB" {
+		t.Errorf("Unexpected RSA JWK %+v", set.Keys[1])
+	}
+}
+
+func Test_LoadKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("Could not marshal key: %s", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "2024-01.pem"), data, 0o600); err != nil {
+		t.Fatalf("Could not write key: %s", err)
+	}
+	ring, err := auth.LoadKeyRing(dir, "2024-01", nil)
+	if err != nil {
+		t.Fatalf("LoadKeyRing should not error: %s", err)
+	}
+	if keys := ring.JWKS().Keys; len(keys) != 1 || keys[0].Algorithm != "EdDSA" {
+		t.Errorf("Unexpected JWKS %+v", keys)
+	}
+	if _, err := auth.LoadKeyRing(dir, "missing", nil); err == nil {
+		t.Error("LoadKeyRing should error when the active key does not exist")
+	}
+}
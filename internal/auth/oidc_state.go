@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const oidcAudience = "chirpy-oidc"
+
+// OIDCState is what we remember between sending a user to an identity
+// provider and the provider sending them back. LinkUserID is set when a
+// logged in user links the provider to their account instead of logging in.
+type OIDCState struct {
+	Provider   string
+	State      string
+	Nonce      string
+	Verifier   string
+	LinkUserID uuid.NullUUID
+}
+
+type oidcStateClaims struct {
+	jwt.RegisteredClaims
+	Provider   string `json:"provider"`
+	State      string `json:"state"`
+	Nonce      string `json:"nonce"`
+	Verifier   string `json:"verifier"`
+	LinkUserID string `json:"link_user_id,omitempty"`
+}
+
+func (k *KeyRing) MakeOIDCState(state OIDCState, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims := oidcStateClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    DefaultIssuer,
+			Audience:  jwt.ClaimStrings{oidcAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		},
+		Provider: state.Provider,
+		State:    state.State,
+		Nonce:    state.Nonce,
+		Verifier: state.Verifier,
+	}
+	if state.LinkUserID.Valid {
+		claims.LinkUserID = state.LinkUserID.UUID.String()
+	}
+	return k.sign(claims)
+}
+
+func (k *KeyRing) ValidateOIDCState(tokenString string) (OIDCState, error) {
+	claims := &oidcStateClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, k.keyFunc,
+		jwt.WithIssuer(DefaultIssuer), jwt.WithAudience(oidcAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return OIDCState{}, err
+	}
+	if !token.Valid {
+		return OIDCState{}, errors.New("Invalid token")
+	}
+	state := OIDCState{
+		Provider: claims.Provider,
+		State:    claims.State,
+		Nonce:    claims.Nonce,
+		Verifier: claims.Verifier,
+	}
+	if claims.LinkUserID != "" {
+		linkUserID, err := uuid.Parse(claims.LinkUserID)
+		if err != nil {
+			return OIDCState{}, err
+		}
+		state.LinkUserID = uuid.NullUUID{UUID: linkUserID, Valid: true}
+	}
+	return state, nil
+}
@@ -0,0 +1,48 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/google/uuid"
+)
+
+func Test_OIDCStateRoundTrip(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	state := auth.OIDCState{
+		Provider:   "google",
+		State:      "state",
+		Nonce:      "nonce",
+		Verifier:   "verifier",
+		LinkUserID: uuid.NullUUID{UUID: uuid.New(), Valid: true},
+	}
+	signed, err := ring.MakeOIDCState(state, time.Minute)
+	if err != nil {
+		t.Fatalf("Could not sign state: %s", err)
+	}
+	got, err := ring.ValidateOIDCState(signed)
+	if err != nil || got != state {
+		t.Errorf("ValidateOIDCState = %+v, %v, expected %+v", got, err, state)
+	}
+}
+
+func Test_OIDCStateIsNotAnAccessToken(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	signed, _ := ring.MakeOIDCState(auth.OIDCState{Provider: "google"}, time.Minute)
+	if _, err := ring.ParseAccessToken(signed); err == nil {
+		t.Error("An OIDC state should not be accepted as an access token")
+	}
+	access, _ := ring.MakeJWT(uuid.New(), uuid.New(), 0, auth.RoleUser, time.Minute)
+	if _, err := ring.ValidateOIDCState(access); err == nil {
+		t.Error("An access token should not be accepted as an OIDC state")
+	}
+}
+
+func Test_OIDCStateExpires(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	signed, _ := ring.MakeOIDCState(auth.OIDCState{Provider: "google"}, -time.Minute)
+	if _, err := ring.ValidateOIDCState(signed); err == nil {
+		t.Error("An expired OIDC state should be rejected")
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PersonalTokenPrefix tells personal access tokens apart from JWTs and makes
+// leaked tokens easy to spot by secret scanners.
+const PersonalTokenPrefix = "chirpy_pat_"
+
+// Scopes limit what a personal access token may do. Routes that manage the
+// account itself, like credentials, sessions and tokens, need a login.
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeChirpsDelete = "chirps:delete"
+	ScopeSocialWrite  = "social:write"
+	ScopeProfileWrite = "profile:write"
+)
+
+var Scopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeChirpsDelete, ScopeSocialWrite, ScopeProfileWrite}
+
+func MakePersonalToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return PersonalTokenPrefix + hex.EncodeToString(bytes), nil
+}
+
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// HashPersonalToken returns the digest stored in place of a personal access
+// token. Like refresh tokens, they are random enough for an unsalted hash.
+func HashPersonalToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateScopes checks that every scope exists and returns them sorted and
+// without duplicates.
+func ValidateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("At least one scope is required. Valid scopes are %s", strings.Join(Scopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("Unknown scope %q. Valid scopes are %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
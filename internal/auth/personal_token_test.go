@@ -0,0 +1,52 @@
+package auth_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+	"github.com/google/uuid"
+)
+
+func Test_MakePersonalToken(t *testing.T) {
+	token, err := auth.MakePersonalToken()
+	if err != nil {
+		t.Fatalf("Could not make token: %s", err)
+	}
+	if !auth.IsPersonalToken(token) {
+		t.Errorf("%s is not recognized as a personal token", token)
+	}
+	other, _ := auth.MakePersonalToken()
+	if token == other {
+		t.Error("Two personal tokens should not be equal")
+	}
+	if auth.HashPersonalToken(token) == token {
+		t.Error("The hash should not be the token")
+	}
+}
+
+func Test_IsPersonalTokenRejectsJWTs(t *testing.T) {
+	ring := auth.NewHMACKeyRing("secret")
+	access, _ := ring.MakeJWT(uuid.New(), uuid.New(), 0, auth.RoleUser, time.Minute)
+	if auth.IsPersonalToken(access) {
+		t.Error("A JWT should not be taken for a personal token")
+	}
+}
+
+func Test_ValidateScopes(t *testing.T) {
+	scopes, err := auth.ValidateScopes([]string{auth.ScopeChirpsWrite, auth.ScopeChirpsRead, auth.ScopeChirpsWrite})
+	if err != nil {
+		t.Fatalf("ValidateScopes: %s", err)
+	}
+	expected := []string{auth.ScopeChirpsRead, auth.ScopeChirpsWrite}
+	if !slices.Equal(scopes, expected) {
+		t.Errorf("ValidateScopes = %v, expected %v", scopes, expected)
+	}
+	if _, err := auth.ValidateScopes([]string{"admin"}); err == nil {
+		t.Error("Unknown scopes should be rejected")
+	}
+	if _, err := auth.ValidateScopes(nil); err == nil {
+		t.Error("A token without scopes should be rejected")
+	}
+}
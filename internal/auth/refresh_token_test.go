@@ -0,0 +1,32 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+)
+
+func Test_HashRefreshTokenIsStable(t *testing.T) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Could not make refresh token: %s", err)
+	}
+	hash := auth.HashRefreshToken(token)
+	if hash == token {
+		t.Fatal("Hash should not equal the token")
+	}
+	if hash != auth.HashRefreshToken(token) {
+		t.Fatal("Hashing the same token twice should give the same digest")
+	}
+	if len(hash) != 64 {
+		t.Fatalf("Expected a hex SHA-256 digest, got %d chars", len(hash))
+	}
+}
+
+func Test_HashRefreshTokenMatchesMigration(t *testing.T) {
+	// The 017 migration computes encode(sha256(token), 'hex') in Postgres.
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got := auth.HashRefreshToken("hello"); got != want {
+		t.Fatalf("Expected %s, got %s", want, got)
+	}
+}
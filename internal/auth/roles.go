@@ -0,0 +1,25 @@
+package auth
+
+import "slices"
+
+// Roles grant privileges on top of the ones of the roles before them: a
+// moderator can do everything a user can, and an admin everything a
+// moderator can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// HasRole reports whether role grants the privileges of required. Unknown
+// roles grant nothing.
+func HasRole(role, required string) bool {
+	have := slices.Index(Roles, role)
+	return have >= 0 && have >= slices.Index(Roles, required)
+}
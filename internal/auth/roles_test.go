@@ -0,0 +1,39 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/auth"
+)
+
+func Test_HasRole(t *testing.T) {
+	cases := []struct {
+		role, required string
+		expected       bool
+	}{
+		{auth.RoleUser, auth.RoleUser, true},
+		{auth.RoleUser, auth.RoleModerator, false},
+		{auth.RoleModerator, auth.RoleModerator, true},
+		{auth.RoleModerator, auth.RoleAdmin, false},
+		{auth.RoleAdmin, auth.RoleModerator, true},
+		{auth.RoleAdmin, auth.RoleAdmin, true},
+		{"root", auth.RoleUser, false},
+		{"", auth.RoleUser, false},
+	}
+	for _, c := range cases {
+		if got := auth.HasRole(c.role, c.required); got != c.expected {
+			t.Errorf("HasRole(%q, %q) = %v, expected %v", c.role, c.required, got, c.expected)
+		}
+	}
+}
+
+func Test_ValidRole(t *testing.T) {
+	for _, role := range auth.Roles {
+		if !auth.ValidRole(role) {
+			t.Errorf("%s should be a valid role", role)
+		}
+	}
+	if auth.ValidRole("superuser") {
+		t.Error("superuser should not be a valid role")
+	}
+}
@@ -20,7 +20,3 @@ func getTokenFromHeader(headers http.Header, prefix string) (string, error) {
 func GetBearerToken(headers http.Header) (string, error) {
 	return getTokenFromHeader(headers, "Bearer")
 }
-
-func GetAPIKey(headers http.Header) (string, error) {
-	return getTokenFromHeader(headers, "ApiKey")
-}
@@ -0,0 +1,81 @@
+package chirptext
+
+import (
+	"strings"
+	"unicode"
+)
+
+const maxHashtagLength = 64
+const maxHandleLength = 30
+
+// Entity is a hashtag or mention found in a chirp body. Start and End are
+// offsets in runes, End being exclusive. Text is normalized to lower case and
+// does not include the leading # or @.
+type Entity struct {
+	Text  string
+	Start int
+	End   int
+}
+
+type Entities struct {
+	Hashtags []Entity
+	Mentions []Entity
+}
+
+// Extract finds #hashtags and @mentions in body. A marker only starts an
+// entity at the beginning of the text or after a character that can not be
+// part of a word, so e-mail addresses and "a#b" are ignored.
+func Extract(body string) Entities {
+	var entities Entities
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		marker := runes[i]
+		if marker != '#' && marker != '@' {
+			continue
+		}
+		if i > 0 && (isHashtagRune(runes[i-1]) || runes[i-1] == '#' || runes[i-1] == '@') {
+			continue
+		}
+		end := i + 1
+		if marker == '#' {
+			for end < len(runes) && isHashtagRune(runes[end]) {
+				end++
+			}
+			text := string(runes[i+1 : end])
+			if end-i-1 <= maxHashtagLength && strings.IndexFunc(text, unicode.IsLetter) >= 0 {
+				entities.Hashtags = append(entities.Hashtags, Entity{Text: strings.ToLower(text), Start: i, End: end})
+			}
+		} else {
+			for end < len(runes) && isHandleRune(runes[end]) {
+				end++
+			}
+			if end > i+1 && end-i-1 <= maxHandleLength && (end == len(runes) || !isHashtagRune(runes[end])) {
+				entities.Mentions = append(entities.Mentions, Entity{Text: strings.ToLower(string(runes[i+1 : end])), Start: i, End: end})
+			}
+		}
+		i = end - 1
+	}
+	return entities
+}
+
+// Unique returns the distinct texts of entities, keeping their first-seen
+// order.
+func Unique(entities []Entity) []string {
+	seen := make(map[string]bool, len(entities))
+	texts := make([]string, 0, len(entities))
+	for _, entity := range entities {
+		if !seen[entity.Text] {
+			seen[entity.Text] = true
+			texts = append(texts, entity.Text)
+		}
+	}
+	return texts
+}
+
+func isHashtagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isHandleRune(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
@@ -0,0 +1,38 @@
+package chirptext_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/chirptext"
+)
+
+func Test_ExtractHashtags(t *testing.T) {
+	entities := chirptext.Extract("Loving #GoLang and #café, not #123 or a#b")
+	expected := []chirptext.Entity{
+		{Text: "golang", Start: 7, End: 14},
+		{Text: "café", Start: 19, End: 24},
+	}
+	if !reflect.DeepEqual(entities.Hashtags, expected) {
+		t.Errorf("Extract hashtags = %+v, expected %+v", entities.Hashtags, expected)
+	}
+}
+
+func Test_ExtractMentions(t *testing.T) {
+	entities := chirptext.Extract("@Alice, ping @bob_2! mail me at carol@example.com")
+	expected := []chirptext.Entity{
+		{Text: "alice", Start: 0, End: 6},
+		{Text: "bob_2", Start: 13, End: 19},
+	}
+	if !reflect.DeepEqual(entities.Mentions, expected) {
+		t.Errorf("Extract mentions = %+v, expected %+v", entities.Mentions, expected)
+	}
+}
+
+func Test_UniqueKeepsFirstSeenOrder(t *testing.T) {
+	entities := chirptext.Extract("#b #a #B")
+	texts := chirptext.Unique(entities.Hashtags)
+	if !reflect.DeepEqual(texts, []string{"b", "a"}) {
+		t.Errorf("Unique = %v, expected [b a]", texts)
+	}
+}
@@ -0,0 +1,31 @@
+package chirptext
+
+import (
+	"errors"
+	"strings"
+)
+
+const minHandleLength = 3
+
+// reservedHandles would shadow routes such as /api/users/me.
+var reservedHandles = []string{"me", "admin", "chirpy", "api"}
+
+// ValidateHandle checks that handle can be used as a username and found again
+// by Extract when it is mentioned.
+func ValidateHandle(handle string) error {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return errors.New("Handle must have between 3 and 30 characters")
+	}
+	for _, r := range handle {
+		if !isHandleRune(r) {
+			return errors.New("Handle may only contain letters, digits and underscores")
+		}
+	}
+	lowered := strings.ToLower(handle)
+	for _, reserved := range reservedHandles {
+		if lowered == reserved {
+			return errors.New("Handle is reserved")
+		}
+	}
+	return nil
+}
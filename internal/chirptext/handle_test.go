@@ -0,0 +1,30 @@
+package chirptext_test
+
+import (
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/chirptext"
+)
+
+func Test_ValidateHandle(t *testing.T) {
+	valid := []string{"bob", "Alice_2024", "a_very_long_handle_of_30_chars"}
+	for _, handle := range valid {
+		if err := chirptext.ValidateHandle(handle); err != nil {
+			t.Errorf("ValidateHandle(%q) should not error: %s", handle, err)
+		}
+	}
+	invalid := []string{"", "ab", "has space", "café", "dash-ed", "Me", "a_very_long_handle_of_31_chars_"}
+	for _, handle := range invalid {
+		if err := chirptext.ValidateHandle(handle); err == nil {
+			t.Errorf("ValidateHandle(%q) should error", handle)
+		}
+	}
+}
+
+func Test_ValidHandlesAreMentionable(t *testing.T) {
+	handle := "Alice_2024"
+	entities := chirptext.Extract("hi @" + handle)
+	if len(entities.Mentions) != 1 || entities.Mentions[0].Text != "alice_2024" {
+		t.Errorf("Extract did not find a mention of %q: %+v", handle, entities.Mentions)
+	}
+}
@@ -7,23 +7,26 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, user_id, body)
-VALUES (gen_random_uuid(),now(),now(),$1,$2) 
-RETURNING id, created_at, updated_at, body, user_id
+INSERT INTO chirps (id, created_at, updated_at, user_id, body, reply_to)
+VALUES (gen_random_uuid(),now(),now(),$1,$2,$3) 
+RETURNING id, created_at, updated_at, body, user_id, search_vector, reply_to, deleted_at
 `
 
 type CreateChirpParams struct {
-	UserID uuid.NullUUID
-	Body   string
+	UserID  uuid.NullUUID
+	Body    string
+	ReplyTo uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body, arg.ReplyTo)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -31,6 +34,9 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.ReplyTo,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -45,7 +51,9 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const findChirpByID = `-- name: FindChirpByID :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+SELECT id, created_at, updated_at, body, user_id, search_vector, reply_to, deleted_at FROM chirps
+WHERE id = $1
+  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
 `
 
 func (q *Queries) FindChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -57,16 +65,106 @@ func (q *Queries) FindChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.ReplyTo,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const findChirpsFromUser = `-- name: FindChirpsFromUser :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+const getReplyTree = `-- name: GetReplyTree :many
+WITH RECURSIVE thread AS (
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.reply_to, chirps.deleted_at, 1 AS depth
+    FROM chirps
+    WHERE chirps.reply_to = $1::uuid
+      AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.reply_to, chirps.deleted_at, thread.depth + 1
+    FROM chirps
+    INNER JOIN thread ON chirps.reply_to = thread.id
+    WHERE thread.depth < $2::int
+      AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+)
+SELECT id, created_at, updated_at, body, user_id, reply_to, deleted_at, depth FROM thread
+ORDER BY depth ASC, created_at ASC, id ASC
+LIMIT 1000
+`
+
+type GetReplyTreeParams struct {
+	RootID   uuid.UUID
+	MaxDepth int32
+}
+
+type GetReplyTreeRow struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.NullUUID
+	SearchVector interface{}
+	ReplyTo      uuid.NullUUID
+	DeletedAt    sql.NullTime
+	Depth        int32
+}
+
+func (q *Queries) GetReplyTree(ctx context.Context, arg GetReplyTreeParams) ([]GetReplyTreeRow, error) {
+	rows, err := q.db.QueryContext(ctx, getReplyTree, arg.RootID, arg.MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReplyTreeRow
+	for rows.Next() {
+		var i GetReplyTreeRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.ReplyTo,
+			&i.DeletedAt,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, reply_to, deleted_at FROM chirps
+WHERE deleted_at IS NULL
+  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND ($1::uuid IS NULL OR user_id = $1::uuid)
+  AND ($2::timestamp IS NULL
+       OR (created_at, id) > ($2::timestamp, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
 `
 
-func (q *Queries) FindChirpsFromUser(ctx context.Context, userID uuid.NullUUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, findChirpsFromUser, userID)
+type ListChirpsAscParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +178,9 @@ func (q *Queries) FindChirpsFromUser(ctx context.Context, userID uuid.NullUUID)
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.ReplyTo,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -94,12 +195,31 @@ func (q *Queries) FindChirpsFromUser(ctx context.Context, userID uuid.NullUUID)
 	return items, nil
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, reply_to, deleted_at FROM chirps
+WHERE deleted_at IS NULL
+  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND ($1::uuid IS NULL OR user_id = $1::uuid)
+  AND ($2::timestamp IS NULL
+       OR (created_at, id) < ($2::timestamp, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type ListChirpsDescParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +233,9 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.ReplyTo,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -126,3 +249,108 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	}
 	return items, nil
 }
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.reply_to, chirps.deleted_at,
+    ts_rank(chirps.search_vector, query)::real AS rank,
+    ts_headline('english', translate(chirps.body, E'\x02\x03', ''), query, E'StartSel=\x02, StopSel=\x03')::text AS snippet
+FROM chirps, websearch_to_tsquery('english', $1) query
+WHERE chirps.search_vector @@ query
+  AND chirps.deleted_at IS NULL
+  AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND ($2::uuid IS NULL OR chirps.user_id = $2::uuid)
+  AND ($3::timestamp IS NULL OR CASE $4::text
+        WHEN 'asc' THEN (chirps.created_at, chirps.id) > ($3::timestamp, $5::uuid)
+        WHEN 'desc' THEN (chirps.created_at, chirps.id) < ($3::timestamp, $5::uuid)
+        ELSE (ts_rank(chirps.search_vector, query)::real, chirps.created_at, chirps.id)
+            < ($6::real, $3::timestamp, $5::uuid)
+      END)
+ORDER BY
+    CASE WHEN $4::text = 'relevance' THEN ts_rank(chirps.search_vector, query)::real END DESC,
+    CASE WHEN $4::text = 'asc' THEN chirps.created_at END ASC,
+    CASE WHEN $4::text = 'asc' THEN chirps.id END ASC,
+    chirps.created_at DESC, chirps.id DESC
+LIMIT $7
+`
+
+type SearchChirpsParams struct {
+	Query           string
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	Sort            string
+	CursorID        uuid.NullUUID
+	CursorRank      sql.NullFloat64
+	PageLimit       int32
+}
+
+type SearchChirpsRow struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.NullUUID
+	SearchVector interface{}
+	ReplyTo      uuid.NullUUID
+	DeletedAt    sql.NullTime
+	Rank         float32
+	Snippet      string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.Sort,
+		arg.CursorID,
+		arg.CursorRank,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.ReplyTo,
+			&i.DeletedAt,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :execrows
+UPDATE chirps
+SET body = '',
+    deleted_at = now(),
+    updated_at = now()
+WHERE id = $1
+  AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.reply_to = $1)
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,77 @@
+// Package mailer sends the transactional emails of the application.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a plain text message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FormatMessage renders msg as an RFC 5322 message sent by from.
+func FormatMessage(from string, msg Message) ([]byte, error) {
+	if strings.ContainsAny(from+msg.To+msg.Subject, "\r\n") {
+		return nil, errors.New("Header values must not contain line breaks")
+	}
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("Invalid recipient: %w", err)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if !strings.HasSuffix(body, "\n") {
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// LogMailer writes messages to w instead of delivering them. It is meant for
+// local development, where w is stdout or a file, and for tests.
+type LogMailer struct {
+	mu   sync.Mutex
+	from string
+	w    io.Writer
+}
+
+func NewLogMailer(from string, w io.Writer) *LogMailer {
+	return &LogMailer{from: from, w: w}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	data, err := FormatMessage(m.from, msg)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := fmt.Fprintf(m.w, "----- mail to %s -----\n", msg.To); err != nil {
+		return err
+	}
+	_, err = m.w.Write(append(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), '\n'))
+	return err
+}
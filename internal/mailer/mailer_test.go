@@ -0,0 +1,121 @@
+package mailer_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/mailer"
+)
+
+func Test_FormatMessage(t *testing.T) {
+	data, err := mailer.FormatMessage("noreply@chirpy.test", mailer.Message{
+		To:      "jo@example.com",
+		Subject: "Réinitialiser",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatalf("FormatMessage should not error: %s", err)
+	}
+	message := string(data)
+	for _, expected := range []string{
+		"From: noreply@chirpy.test\r\n",
+		"To: jo@example.com\r\n",
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Message should contain %q, got:\n%s", expected, message)
+		}
+	}
+}
+
+func Test_FormatMessageRejectsHeaderInjection(t *testing.T) {
+	_, err := mailer.FormatMessage("noreply@chirpy.test", mailer.Message{
+		To:      "jo@example.com\r\nBcc: eve@example.com",
+		Subject: "Hi",
+	})
+	if err == nil {
+		t.Error("FormatMessage should reject line breaks in headers")
+	}
+}
+
+func Test_LogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := mailer.NewLogMailer("noreply@chirpy.test", &buf)
+	err := m.Send(context.Background(), mailer.Message{To: "jo@example.com", Subject: "Hi", Body: "token: abc"})
+	if err != nil {
+		t.Fatalf("Send should not error: %s", err)
+	}
+	if !strings.Contains(buf.String(), "mail to jo@example.com") || !strings.Contains(buf.String(), "token: abc") {
+		t.Errorf("Unexpected log output:\n%s", buf.String())
+	}
+}
+
+// fakeSMTPServer accepts a single message and sends its DATA to received.
+func fakeSMTPServer(t *testing.T, received chan<- string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 Go ahead")
+				data, _ := text.ReadDotLines()
+				received <- strings.Join(data, "\n")
+				text.PrintfLine("250 Queued")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				return
+			default:
+				text.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func Test_SMTPMailerDeliversMessage(t *testing.T) {
+	received := make(chan string, 1)
+	host, port, _ := net.SplitHostPort(fakeSMTPServer(t, received))
+	m := mailer.NewSMTPMailer(host, port, "", "", "noreply@chirpy.test")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := m.Send(ctx, mailer.Message{To: "jo@example.com", Subject: "Hi", Body: "Hello there"})
+	if err != nil {
+		t.Fatalf("Send should not error: %s", err)
+	}
+	data := <-received
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	var sawBody bool
+	for scanner.Scan() {
+		if scanner.Text() == "Hello there" {
+			sawBody = true
+		}
+	}
+	if !sawBody {
+		t.Errorf("Server did not receive the body, got:\n%s", data)
+	}
+}
@@ -0,0 +1,161 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const MaxUploadSize = 5 << 20
+const ThumbnailSize = 320
+
+// MaxPixels bounds the dimensions of an upload. A few kilobytes of PNG or GIF
+// can declare an image that takes gigabytes to decode.
+const MaxPixels = 40_000_000
+
+var ErrUnsupportedType = errors.New("Unsupported media type. Use PNG, JPEG or GIF")
+var ErrImageTooLarge = errors.New("Image dimensions too large")
+
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// Image is a validated upload together with its thumbnail.
+type Image struct {
+	ContentType string
+	Extension   string
+	Width       int
+	Height      int
+	Thumbnail   []byte
+}
+
+// ProcessImage sniffs the content type of an upload instead of trusting the
+// client, checks the declared dimensions, decodes it and renders a thumbnail.
+func ProcessImage(content []byte) (Image, error) {
+	if len(content) > MaxUploadSize {
+		return Image{}, errors.New("File too large")
+	}
+	contentType := http.DetectContentType(content)
+	extension, ok := extensions[contentType]
+	if !ok {
+		return Image{}, ErrUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return Image{}, errors.New("Could not decode image")
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return Image{}, ErrImageTooLarge
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return Image{}, errors.New("Could not decode image")
+	}
+	thumbnail, err := encode(Thumbnail(decoded, ThumbnailSize), contentType)
+	if err != nil {
+		return Image{}, err
+	}
+	bounds := decoded.Bounds()
+	return Image{
+		ContentType: contentType,
+		Extension:   extension,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Thumbnail:   thumbnail,
+	}, nil
+}
+
+// Thumbnail scales img down so that its largest side is at most maxSize,
+// averaging the source pixels covered by each destination pixel. Images that
+// already fit are returned unchanged.
+func Thumbnail(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+	thumbWidth, thumbHeight := maxSize, height*maxSize/width
+	if height > width {
+		thumbWidth, thumbHeight = width*maxSize/height, maxSize
+	}
+	thumbWidth, thumbHeight = max(thumbWidth, 1), max(thumbHeight, 1)
+
+	// The source is converted one band of rows at a time, so that averaging
+	// reads bytes instead of calling At for every pixel.
+	band := image.NewRGBA(image.Rect(0, 0, width, (height+thumbHeight-1)/thumbHeight+1))
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0 := y * height / thumbHeight
+		y1 := max((y+1)*height/thumbHeight, y0+1)
+		copyRows(band, img, bounds.Min.Y+y0, bounds.Min.Y+y1)
+		for x := 0; x < thumbWidth; x++ {
+			x0 := x * width / thumbWidth
+			x1 := max((x+1)*width/thumbWidth, x0+1)
+			var sum [4]int
+			for by := 0; by < y1-y0; by++ {
+				row := band.Pix[by*band.Stride+x0*4 : by*band.Stride+x1*4]
+				for i, v := range row {
+					sum[i%4] += int(v)
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			offset := thumb.PixOffset(x, y)
+			for i := range sum {
+				thumb.Pix[offset+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return thumb
+}
+
+// copyRows draws the rows y0 to y1 of img to the top of band. Paletted
+// images, which is what GIFs and many PNGs decode to, are looked up in the
+// palette directly since image/draw falls back to At for them.
+func copyRows(band *image.RGBA, img image.Image, y0, y1 int) {
+	bounds := img.Bounds()
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		draw.Draw(band, image.Rect(0, 0, bounds.Dx(), y1-y0), img, image.Pt(bounds.Min.X, y0), draw.Src)
+		return
+	}
+	palette := make([][4]uint8, len(paletted.Palette))
+	for i, c := range paletted.Palette {
+		r, g, b, a := c.RGBA()
+		palette[i] = [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	}
+	for y := y0; y < y1; y++ {
+		src := paletted.Pix[paletted.PixOffset(bounds.Min.X, y):][:bounds.Dx()]
+		dst := band.Pix[(y-y0)*band.Stride:]
+		for x, index := range src {
+			if int(index) < len(palette) {
+				copy(dst[x*4:x*4+4], palette[index][:])
+			} else {
+				clear(dst[x*4 : x*4+4])
+			}
+		}
+	}
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/png":
+		err = png.Encode(&buffer, img)
+	case "image/gif":
+		err = gif.Encode(&buffer, img, nil)
+	default:
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+package media_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/media"
+)
+
+func Test_ThumbnailKeepsAspectRatio(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	thumb := media.Thumbnail(img, 100)
+	if thumb.Bounds().Dx() != 100 || thumb.Bounds().Dy() != 50 {
+		t.Errorf("Thumbnail(1000x500, 100) = %v, expected 100x50", thumb.Bounds().Size())
+	}
+}
+
+func Test_ThumbnailDoesNotUpscale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	thumb := media.Thumbnail(img, 100)
+	if thumb.Bounds().Size() != img.Bounds().Size() {
+		t.Errorf("Thumbnail(20x10, 100) = %v, expected it unchanged", thumb.Bounds().Size())
+	}
+}
+
+func Test_ProcessImage(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 640, 480))); err != nil {
+		t.Fatalf("Could not encode test image: %s", err)
+	}
+	processed, err := media.ProcessImage(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("ProcessImage should not error: %s", err)
+	}
+	if processed.ContentType != "image/png" || processed.Width != 640 || processed.Height != 480 {
+		t.Errorf("ProcessImage returned %+v", processed)
+	}
+	thumb, err := png.Decode(bytes.NewReader(processed.Thumbnail))
+	if err != nil {
+		t.Fatalf("Thumbnail is not a valid png: %s", err)
+	}
+	if thumb.Bounds().Dx() != media.ThumbnailSize {
+		t.Errorf("Thumbnail width = %d, expected %d", thumb.Bounds().Dx(), media.ThumbnailSize)
+	}
+}
+
+func Test_ProcessImageRejectsOtherTypes(t *testing.T) {
+	_, err := media.ProcessImage([]byte("<html><body>not an image</body></html>"))
+	if !errors.Is(err, media.ErrUnsupportedType) {
+		t.Errorf("ProcessImage(html) error = %v, expected %v", err, media.ErrUnsupportedType)
+	}
+}
+
+func Test_ThumbnailAveragesPalettedImages(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 400, 400), color.Palette{color.Black, color.White})
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x += 2 {
+			img.SetColorIndex(x, y, 1)
+		}
+	}
+	thumb := media.Thumbnail(img, 200)
+	r, g, b, a := thumb.At(100, 100).RGBA()
+	if r>>8 != 127 || g>>8 != 127 || b>>8 != 127 || a>>8 != 255 {
+		t.Errorf("Thumbnail pixel = %d %d %d %d, expected the average of black and white", r>>8, g>>8, b>>8, a>>8)
+	}
+}
+
+func Test_ProcessImageRejectsHugeDimensions(t *testing.T) {
+	// Only the header is needed: the dimensions are checked before decoding.
+	header := make([]byte, 13)
+	binary.BigEndian.PutUint32(header[0:4], 100_000)
+	binary.BigEndian.PutUint32(header[4:8], 100_000)
+	header[8], header[9] = 8, 2
+	chunk := append([]byte("IHDR"), header...)
+	var content bytes.Buffer
+	content.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&content, binary.BigEndian, uint32(len(header)))
+	content.Write(chunk)
+	binary.Write(&content, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+
+	_, err := media.ProcessImage(content.Bytes())
+	if !errors.Is(err, media.ErrImageTooLarge) {
+		t.Errorf("ProcessImage(100000x100000 png) error = %v, expected %v", err, media.ErrImageTooLarge)
+	}
+}
@@ -0,0 +1,175 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OpenID provider that issues an ID token for a
+// single pending authorization code.
+type mockProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	code      string
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != m.code || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		m.code = ""
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+		token.Header["kid"] = "mock"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize plays the part of the user signing in at the provider.
+func (m *mockProvider) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Expected an S256 challenge, got %q", query.Get("code_challenge_method"))
+	}
+	m.code = "code-123"
+	m.challenge = query.Get("code_challenge")
+	m.claims = jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            query.Get("client_id"),
+		"sub":            "user-1",
+		"email":          "jo@example.com",
+		"email_verified": "true",
+		"nonce":          query.Get("nonce"),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+	return m.code, query.Get("state")
+}
+
+func newProvider(m *mockProvider) *oidc.Provider {
+	return oidc.NewProvider(oidc.Config{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    "chirpy",
+		RedirectURL: "http://localhost/api/auth/mock/callback",
+	}, m.server.Client())
+}
+
+func Test_CodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	provider := newProvider(m)
+	ctx := context.Background()
+	state, _ := oidc.RandomString()
+	nonce, _ := oidc.RandomString()
+	verifier, _ := oidc.RandomString()
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %s", err)
+	}
+	if !strings.HasPrefix(authURL, m.server.URL+"/authorize?") {
+		t.Errorf("Unexpected authorization URL %s", authURL)
+	}
+	code, returnedState := m.authorize(t, authURL)
+	if returnedState != state {
+		t.Errorf("State = %q, expected %q", returnedState, state)
+	}
+	claims, err := provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %s", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "jo@example.com" || !claims.EmailVerified {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+}
+
+func Test_CodeFlowRejectsWrongVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	provider := newProvider(m)
+	ctx := context.Background()
+	authURL, _ := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	code, _ := m.authorize(t, authURL)
+	if _, err := provider.Exchange(ctx, code, "other-verifier", "nonce"); err == nil {
+		t.Error("Exchange should fail when the PKCE verifier does not match")
+	}
+}
+
+func Test_CodeFlowRejectsWrongNonce(t *testing.T) {
+	m := newMockProvider(t)
+	provider := newProvider(m)
+	ctx := context.Background()
+	authURL, _ := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	code, _ := m.authorize(t, authURL)
+	if _, err := provider.Exchange(ctx, code, "verifier", "other-nonce"); err == nil {
+		t.Error("Exchange should fail when the nonce does not match")
+	}
+}
+
+func Test_CodeFlowRejectsWrongAudience(t *testing.T) {
+	m := newMockProvider(t)
+	provider := newProvider(m)
+	ctx := context.Background()
+	authURL, _ := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	code, _ := m.authorize(t, authURL)
+	m.claims["aud"] = "someone-else"
+	if _, err := provider.Exchange(ctx, code, "verifier", "nonce"); err == nil {
+		t.Error("Exchange should fail for an ID token issued to another client")
+	}
+}
+
+func Test_CodeChallenge(t *testing.T) {
+	got := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K1uQvN5ZffE3FiUM9oRsS-_XUQ")
+	if got != "XdhRfuWW6ezHZYpUF3iRwD7q6tQ3Q3nuaexnp9a4IJk" {
+		t.Errorf("CodeChallenge = %s", got)
+	}
+}
@@ -0,0 +1,61 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker reports how often a password appeared in known data breaches.
+type BreachChecker interface {
+	Breaches(ctx context.Context, password string) (int, error)
+}
+
+// RangeDirChecker looks passwords up in an offline copy of the Pwned
+// Passwords range files: one file per 5 character SHA-1 prefix, named like
+// 21BD1.txt, holding "SUFFIX:COUNT" lines. Like the online k-anonymity API
+// only the bucket of the prefix is ever read.
+type RangeDirChecker struct {
+	dir string
+}
+
+func NewRangeDirChecker(dir string) *RangeDirChecker {
+	return &RangeDirChecker{dir: dir}
+}
+
+func (c *RangeDirChecker) Breaches(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	file, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		lineSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,89 @@
+package password_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/password"
+)
+
+func Test_StrengthScoresCommonPatternsLow(t *testing.T) {
+	weak := []string{"password", "P@ssw0rd", "qwertyuiop", "12345678", "aaaaaaaa", "abcdefgh", "maria1990maria", "letmein2020"}
+	for _, candidate := range weak {
+		if score := password.Strength(candidate, "maria"); score > 1 {
+			t.Errorf("Strength(%q) = %d, expected at most 1", candidate, score)
+		}
+	}
+}
+
+func Test_StrengthScoresEnglishWordsLow(t *testing.T) {
+	for _, candidate := range []string{"elephant", "umbrella", "Wilderness", "purplemonkey"} {
+		if score := password.Strength(candidate); score > 1 {
+			t.Errorf("Strength(%q) = %d, expected at most 1", candidate, score)
+		}
+	}
+}
+
+func Test_StrengthScoresRandomPasswordsHigh(t *testing.T) {
+	strong := []string{"correct horse battery staple", "X9#kq2!Lmz7$", "tunnel-cactus-violin-58"}
+	for _, candidate := range strong {
+		if score := password.Strength(candidate); score < 3 {
+			t.Errorf("Strength(%q) = %d, expected at least 3", candidate, score)
+		}
+	}
+}
+
+func Test_StrengthPenalizesUserInputs(t *testing.T) {
+	if password.Strength("mariana1987", "mariana") >= password.Strength("mariana1987") {
+		t.Error("A password containing the user's name should score lower")
+	}
+}
+
+func Test_PolicyCheck(t *testing.T) {
+	policy := password.DefaultPolicy
+	cases := map[string]string{
+		"short":                 "at least 8 characters",
+		strings.Repeat("é", 40): "not be longer than 72 bytes",
+		"password1":             "too easy to guess",
+	}
+	for candidate, expected := range cases {
+		err := policy.Check(context.Background(), candidate)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Check(%q) = %v, expected an error containing %q", candidate, err, expected)
+		}
+	}
+	if err := policy.Check(context.Background(), "tunnel-cactus-violin-58"); err != nil {
+		t.Errorf("Check should accept a strong password: %s", err)
+	}
+}
+
+func Test_RangeDirCheckerFindsBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("tunnel-cactus-violin-58"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	bucket := "0018A45C4D1DEF81644B54AB7F969B88D65:1\n" + hash[5:] + ":42\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(bucket), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	checker := password.NewRangeDirChecker(dir)
+	count, err := checker.Breaches(context.Background(), "tunnel-cactus-violin-58")
+	if err != nil || count != 42 {
+		t.Errorf("Breaches = %d, %v, expected 42", count, err)
+	}
+	count, err = checker.Breaches(context.Background(), "another-cactus-violin-58")
+	if err != nil || count != 0 {
+		t.Errorf("Breaches = %d, %v, expected 0", count, err)
+	}
+
+	policy := password.DefaultPolicy
+	policy.Breaches = checker
+	err = policy.Check(context.Background(), "tunnel-cactus-violin-58")
+	if err == nil || !strings.Contains(err.Error(), "data breach") {
+		t.Errorf("Check should reject a breached password, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package password decides which passwords users may choose.
+package password
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+)
+
+// bcryptMaxBytes is the length after which bcrypt ignores the rest of the
+// password.
+const bcryptMaxBytes = 72
+
+type Policy struct {
+	MinLength int
+	// MaxBytes is counted in bytes, not characters, since that is what the
+	// hash function limits.
+	MaxBytes int
+	MinScore Score
+	// Breaches is optional. When set, passwords found in it are rejected.
+	Breaches BreachChecker
+}
+
+var DefaultPolicy = Policy{
+	MinLength: 8,
+	MaxBytes:  bcryptMaxBytes,
+	MinScore:  2,
+}
+
+// Check returns an error meant for the user when password does not follow
+// the policy. userInputs are things like the email and handle of the user,
+// which make a password easier to guess.
+func (p Policy) Check(ctx context.Context, password string, userInputs ...string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("Password must have at least %d characters.", p.MinLength)
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		return fmt.Errorf("Password must not be longer than %d bytes. Characters outside of ASCII take more than one byte.", p.MaxBytes)
+	}
+	if Strength(password, userInputs...) < p.MinScore {
+		return errors.New("Password is too easy to guess. Avoid common words, names, dates and keyboard patterns.")
+	}
+	if p.Breaches != nil {
+		count, err := p.Breaches.Breaches(ctx, password)
+		if err != nil {
+			// An unavailable breach list should not stop people from signing up.
+			slog.Error("Could not check password against breaches", "error", err)
+			return nil
+		}
+		if count > 0 {
+			return errors.New("Password appeared in a data breach. Choose another one.")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package password
+
+import (
+	"cmp"
+	_ "embed"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+//go:embed common.txt
+var commonList string
+
+// commonPasswords maps frequent passwords and words to their rank.
+var commonPasswords = func() map[string]int {
+	ranks := map[string]int{}
+	for i, word := range strings.Fields(commonList) {
+		ranks[word] = i + 1
+	}
+	return ranks
+}()
+
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+var leetSubstitutions = strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+// Score rates a password from 0 (trivial to guess) to 4 (very hard to guess),
+// like zxcvbn does.
+type Score int
+
+// match is a part of the password an attacker would guess as a whole.
+type match struct {
+	start, end int
+	guesses    float64
+}
+
+// Strength estimates how hard password is to guess. userInputs, such as the
+// email or handle of the user, count as common words.
+func Strength(password string, userInputs ...string) Score {
+	return scoreFromGuesses(estimateGuesses(password, userInputs))
+}
+
+func scoreFromGuesses(guesses float64) Score {
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// estimateGuesses covers the password with the cheapest patterns it finds,
+// longest first, and brute forces whatever is left over.
+func estimateGuesses(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 1
+	}
+	lowered := []rune(strings.ToLower(password))
+	matches := findMatches(lowered, userInputs)
+	covered := make([]bool, len(runes))
+	log10Guesses := 0.0
+	patterns := 0
+	for _, m := range matches {
+		free := true
+		for i := m.start; i < m.end; i++ {
+			free = free && !covered[i]
+		}
+		if !free {
+			continue
+		}
+		for i := m.start; i < m.end; i++ {
+			covered[i] = true
+		}
+		log10Guesses += math.Log10(m.guesses)
+		patterns++
+	}
+	perChar := math.Log10(float64(characterPool(runes)))
+	for i := range runes {
+		if !covered[i] {
+			log10Guesses += perChar
+		}
+	}
+	// Attackers also have to guess how the pieces were put together.
+	if patterns > 1 {
+		log10Guesses += math.Log10(float64(patterns))
+	}
+	return math.Pow(10, log10Guesses)
+}
+
+func characterPool(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+	return pool
+}
+
+// findMatches returns the guessable patterns in lowered, longest first.
+func findMatches(lowered []rune, userInputs []string) []match {
+	var matches []match
+	dictionary := func(word string) (int, bool) {
+		for _, input := range userInputs {
+			if strings.EqualFold(word, input) {
+				return 1, true
+			}
+		}
+		rank, ok := commonPasswords[word]
+		return rank, ok
+	}
+	for start := 0; start < len(lowered); start++ {
+		for end := start + 3; end <= len(lowered); end++ {
+			word := string(lowered[start:end])
+			if rank, ok := dictionary(word); ok {
+				matches = append(matches, match{start, end, float64(rank) * 2})
+			} else if rank, ok := dictionary(leetSubstitutions.Replace(word)); ok {
+				matches = append(matches, match{start, end, float64(rank) * 4})
+			}
+			if isRepeat(lowered[start:end]) || isSequence(lowered[start:end]) {
+				matches = append(matches, match{start, end, float64(26 * (end - start))})
+			} else if isKeyboardWalk(word) {
+				matches = append(matches, match{start, end, float64(94 * (end - start))})
+			}
+		}
+		if start+4 <= len(lowered) && isYear(string(lowered[start:start+4])) {
+			matches = append(matches, match{start, start + 4, 120})
+		}
+	}
+	// Prefer long matches, then cheap ones.
+	slices.SortStableFunc(matches, func(a, b match) int {
+		if a.end-a.start != b.end-b.start {
+			return (b.end - b.start) - (a.end - a.start)
+		}
+		return cmp.Compare(a.guesses, b.guesses)
+	})
+	return matches
+}
+
+func isRepeat(runes []rune) bool {
+	for _, r := range runes[1:] {
+		if r != runes[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func isSequence(runes []rune) bool {
+	step := runes[1] - runes[0]
+	if step != 1 && step != -1 {
+		return false
+	}
+	for i := 2; i < len(runes); i++ {
+		if runes[i]-runes[i-1] != step {
+			return false
+		}
+	}
+	return true
+}
+
+func isKeyboardWalk(word string) bool {
+	if len(word) < 4 {
+		return false
+	}
+	for _, row := range keyboardRows {
+		if strings.Contains(row, word) || strings.Contains(reverse(row), word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isYear(s string) bool {
+	return (strings.HasPrefix(s, "19") || strings.HasPrefix(s, "20")) && strings.Trim(s, "0123456789") == ""
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
@@ -0,0 +1,137 @@
+package profanity
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const DefaultReplacement = "****"
+
+const (
+	MatchWord      = "word"
+	MatchSubstring = "substring"
+	MatchRegex     = "regex"
+)
+
+type Rule struct {
+	Pattern   string
+	MatchType string
+}
+
+// Filter censors profane words in a text. It is immutable once built, so it is
+// safe to share between goroutines.
+type Filter struct {
+	replacement string
+	words       map[string]struct{}
+	substrings  []string
+	patterns    []*regexp.Regexp
+}
+
+func ValidateRule(rule Rule) error {
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return errors.New("Pattern must not be empty")
+	}
+	switch rule.MatchType {
+	case MatchWord, MatchSubstring:
+		return nil
+	case MatchRegex:
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid regex: %w", err)
+		}
+		// A pattern matching the empty string would insert the replacement
+		// between every character.
+		if re.MatchString("") {
+			return errors.New("Regex must not match the empty string")
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown match type %q", rule.MatchType)
+	}
+}
+
+func NewFilter(rules []Rule, replacement string) (*Filter, error) {
+	filter := &Filter{
+		replacement: replacement,
+		words:       make(map[string]struct{}),
+	}
+	for _, rule := range rules {
+		if err := ValidateRule(rule); err != nil {
+			return nil, err
+		}
+		switch rule.MatchType {
+		case MatchWord:
+			filter.words[fold(rule.Pattern)] = struct{}{}
+		case MatchSubstring:
+			filter.substrings = append(filter.substrings, fold(rule.Pattern))
+		case MatchRegex:
+			filter.patterns = append(filter.patterns, regexp.MustCompile("(?i)"+rule.Pattern))
+		}
+	}
+	return filter, nil
+}
+
+// WordFilter builds a filter that only matches whole words.
+func WordFilter(words []string, replacement string) *Filter {
+	rules := make([]Rule, len(words))
+	for i, word := range words {
+		rules[i] = Rule{Pattern: word, MatchType: MatchWord}
+	}
+	filter, _ := NewFilter(rules, replacement)
+	return filter
+}
+
+// Clean replaces every profane token in text. Tokens are runs of letters,
+// marks and digits, so punctuation around a word does not hide it and is kept
+// in the output.
+func (f *Filter) Clean(text string) string {
+	var builder strings.Builder
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		if !isWordRune(runes[start]) {
+			builder.WriteRune(runes[start])
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		token := string(runes[start:end])
+		if f.isProfane(token) {
+			builder.WriteString(f.replacement)
+		} else {
+			builder.WriteString(token)
+		}
+		start = end
+	}
+	cleaned := builder.String()
+	for _, pattern := range f.patterns {
+		cleaned = pattern.ReplaceAllLiteralString(cleaned, f.replacement)
+	}
+	return cleaned
+}
+
+func (f *Filter) isProfane(token string) bool {
+	folded := fold(token)
+	if _, ok := f.words[folded]; ok {
+		return true
+	}
+	for _, substring := range f.substrings {
+		if strings.Contains(folded, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r)
+}
+
+func fold(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
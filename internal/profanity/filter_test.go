@@ -0,0 +1,63 @@
+package profanity_test
+
+import (
+	"testing"
+
+	"github.com/JP-Go/http-server-go/internal/profanity"
+)
+
+func Test_CleanWordsWithPunctuation(t *testing.T) {
+	filter := profanity.WordFilter([]string{"kerfuffle", "fornax"}, profanity.DefaultReplacement)
+	input := "What a Kerfuffle! Is that a fornax, or not?"
+	expected := "What a ****! Is that a ****, or not?"
+	if cleaned := filter.Clean(input); cleaned != expected {
+		t.Errorf("Clean(%q) = %q, expected %q", input, cleaned, expected)
+	}
+}
+
+func Test_CleanKeepsWordsThatOnlyContainAProfaneWord(t *testing.T) {
+	filter := profanity.WordFilter([]string{"fornax"}, profanity.DefaultReplacement)
+	input := "fornaxes are fine"
+	if cleaned := filter.Clean(input); cleaned != input {
+		t.Errorf("Clean(%q) = %q, expected it unchanged", input, cleaned)
+	}
+}
+
+func Test_CleanUnicode(t *testing.T) {
+	filter := profanity.WordFilter([]string{"ÉCLAIR"}, profanity.DefaultReplacement)
+	input := "un éclair, deux Éclairs"
+	expected := "un ****, deux Éclairs"
+	if cleaned := filter.Clean(input); cleaned != expected {
+		t.Errorf("Clean(%q) = %q, expected %q", input, cleaned, expected)
+	}
+}
+
+func Test_CleanSubstringAndRegexRules(t *testing.T) {
+	filter, err := profanity.NewFilter([]profanity.Rule{
+		{Pattern: "sharb", MatchType: profanity.MatchSubstring},
+		{Pattern: `f+o+r+n+a+x`, MatchType: profanity.MatchRegex},
+	}, profanity.DefaultReplacement)
+	if err != nil {
+		t.Fatalf("NewFilter should not error: %s", err)
+	}
+	input := "Sharberts and ffooorrnax"
+	expected := "**** and ****"
+	if cleaned := filter.Clean(input); cleaned != expected {
+		t.Errorf("Clean(%q) = %q, expected %q", input, cleaned, expected)
+	}
+}
+
+func Test_NewFilterRejectsInvalidRules(t *testing.T) {
+	invalid := []profanity.Rule{
+		{Pattern: "(", MatchType: profanity.MatchRegex},
+		{Pattern: "x*", MatchType: profanity.MatchRegex},
+		{Pattern: "a?", MatchType: profanity.MatchRegex},
+		{Pattern: " ", MatchType: profanity.MatchWord},
+		{Pattern: "word", MatchType: "prefix"},
+	}
+	for _, rule := range invalid {
+		if _, err := profanity.NewFilter([]profanity.Rule{rule}, profanity.DefaultReplacement); err == nil {
+			t.Errorf("NewFilter(%v) should error", rule)
+		}
+	}
+}
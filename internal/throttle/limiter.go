@@ -0,0 +1,148 @@
+// Package throttle slows down repeated failures, such as wrong passwords, with
+// an exponential backoff that ends in a temporary lockout.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy describes how a key is throttled after consecutive failures.
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay.
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure past FreeAttempts. It
+	// doubles with every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold is the number of failures that locks the key for
+	// LockoutDuration.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long failures are remembered without a new one.
+	Window time.Duration
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+}
+
+// Limiter tracks failures per key. It is safe for concurrent use.
+type Limiter struct {
+	mu        sync.Mutex
+	policy    Policy
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+func NewLimiter(policy Policy) *Limiter {
+	return &Limiter{policy: policy, entries: map[string]*entry{}}
+}
+
+// blockedUntil returns until when e is blocked, the zero time if it is not.
+func (l *Limiter) blockedUntil(e *entry) time.Time {
+	if l.policy.LockoutThreshold > 0 && e.failures >= l.policy.LockoutThreshold {
+		return e.lastFailure.Add(l.policy.LockoutDuration)
+	}
+	excess := e.failures - l.policy.FreeAttempts
+	if excess <= 0 {
+		return time.Time{}
+	}
+	delay := l.policy.BaseDelay
+	for i := 1; i < excess && delay < l.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.policy.MaxDelay {
+		delay = l.policy.MaxDelay
+	}
+	return e.lastFailure.Add(delay)
+}
+
+func (l *Limiter) expired(e *entry, now time.Time) bool {
+	return now.Sub(e.lastFailure) > l.policy.Window && !now.Before(l.blockedUntil(e))
+}
+
+// Allow reports whether key may make an attempt at now and, if not, how long
+// it has to wait.
+func (l *Limiter) Allow(key string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0, true
+	}
+	if l.expired(e, now) {
+		delete(l.entries, key)
+		return 0, true
+	}
+	until := l.blockedUntil(e)
+	if now.Before(until) {
+		return until.Sub(now), false
+	}
+	return 0, true
+}
+
+// Fail records a failed attempt of key at now.
+func (l *Limiter) Fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fail(key, now)
+}
+
+func (l *Limiter) fail(key string, now time.Time) {
+	l.sweep(now)
+	e, ok := l.entries[key]
+	if !ok || l.expired(e, now) {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+}
+
+// Reserve is Allow and Fail in one step: an allowed attempt is counted as a
+// failure right away, so concurrent attempts can not all slip through before
+// the first one fails. Attempts that turn out well are given back with Refund
+// or Reset.
+func (l *Limiter) Reserve(key string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok && !l.expired(e, now) {
+		if until := l.blockedUntil(e); now.Before(until) {
+			return until.Sub(now), false
+		}
+	}
+	l.fail(key, now)
+	return 0, true
+}
+
+// Refund gives back an attempt taken with Reserve.
+func (l *Limiter) Refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok && e.failures > 0 {
+		e.failures--
+	}
+}
+
+// Reset forgets the failures of key, for example after a successful login.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// sweep drops expired entries at most once per window so memory stays bounded
+// by the keys seen recently.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.policy.Window {
+		return
+	}
+	l.lastSweep = now
+	for key, e := range l.entries {
+		if l.expired(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package throttle_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/throttle"
+)
+
+var testPolicy = throttle.Policy{
+	FreeAttempts:     2,
+	BaseDelay:        time.Second,
+	MaxDelay:         8 * time.Second,
+	LockoutThreshold: 10,
+	LockoutDuration:  15 * time.Minute,
+	Window:           time.Hour,
+}
+
+func Test_LimiterAllowsFreeAttempts(t *testing.T) {
+	limiter := throttle.NewLimiter(testPolicy)
+	now := time.Now()
+	for i := 0; i < testPolicy.FreeAttempts; i++ {
+		if _, ok := limiter.Allow("jo", now); !ok {
+			t.Fatalf("Attempt %d should be allowed", i+1)
+		}
+		limiter.Fail("jo", now)
+	}
+	if _, ok := limiter.Allow("jo", now); !ok {
+		t.Error("Attempts within FreeAttempts should not be delayed")
+	}
+}
+
+func Test_LimiterBacksOffExponentially(t *testing.T) {
+	limiter := throttle.NewLimiter(testPolicy)
+	now := time.Now()
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i := 0; i < testPolicy.FreeAttempts; i++ {
+		limiter.Fail("jo", now)
+	}
+	for _, delay := range expected {
+		limiter.Fail("jo", now)
+		retryAfter, ok := limiter.Allow("jo", now)
+		if ok || retryAfter != delay {
+			t.Errorf("Allow = %v, %v, expected a delay of %v", retryAfter, ok, delay)
+		}
+		if _, ok := limiter.Allow("jo", now.Add(delay)); !ok {
+			t.Errorf("Attempt should be allowed again after %v", delay)
+		}
+	}
+	if _, ok := limiter.Allow("someone-else", now); !ok {
+		t.Error("Other keys should not be throttled")
+	}
+}
+
+func Test_LimiterLocksOutAfterThreshold(t *testing.T) {
+	limiter := throttle.NewLimiter(testPolicy)
+	now := time.Now()
+	for i := 0; i < testPolicy.LockoutThreshold; i++ {
+		limiter.Fail("jo", now)
+	}
+	retryAfter, ok := limiter.Allow("jo", now)
+	if ok || retryAfter != testPolicy.LockoutDuration {
+		t.Errorf("Allow = %v, %v, expected a lockout of %v", retryAfter, ok, testPolicy.LockoutDuration)
+	}
+	if _, ok := limiter.Allow("jo", now.Add(testPolicy.LockoutDuration)); !ok {
+		t.Error("Lockout should end after LockoutDuration")
+	}
+}
+
+func Test_LimiterForgetsAfterWindowAndReset(t *testing.T) {
+	limiter := throttle.NewLimiter(testPolicy)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.Fail("jo", now)
+	}
+	limiter.Fail("jo", now.Add(testPolicy.Window+time.Minute))
+	if _, ok := limiter.Allow("jo", now.Add(testPolicy.Window+time.Minute)); !ok {
+		t.Error("Failures older than Window should be forgotten")
+	}
+	for i := 0; i < 5; i++ {
+		limiter.Fail("jo", now)
+	}
+	limiter.Reset("jo")
+	if _, ok := limiter.Allow("jo", now); !ok {
+		t.Error("Reset should forget failures")
+	}
+}
+
+func Test_LimiterReserveCountsConcurrentAttempts(t *testing.T) {
+	limiter := throttle.NewLimiter(testPolicy)
+	now := time.Now()
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := limiter.Reserve("jo", now); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := int(allowed.Load()); got != testPolicy.FreeAttempts+1 {
+		t.Errorf("%d concurrent attempts were allowed, expected %d", got, testPolicy.FreeAttempts+1)
+	}
+}
+
+func Test_LimiterRefund(t *testing.T) {
+	limiter := throttle.NewLimiter(testPolicy)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, ok := limiter.Reserve("jo", now); !ok {
+			t.Fatalf("Attempt %d should be allowed after refunds", i+1)
+		}
+		limiter.Refund("jo")
+	}
+}
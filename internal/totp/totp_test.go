@@ -0,0 +1,84 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/totp"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func Test_CodeMatchesRFC6238Vectors(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := totp.Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Code should not error: %s", err)
+		}
+		if code != expected {
+			t.Errorf("Code at %d = %s, expected %s", unix, code, expected)
+		}
+	}
+}
+
+func Test_ValidateAllowsSkewAndRejectsReplay(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret should not error: %s", err)
+	}
+	now := time.Now()
+	previous, _ := totp.Code(secret, now.Add(-totp.Period))
+	step, ok := totp.Validate(secret, previous, now, 0)
+	if !ok || step != totp.Step(now)-1 {
+		t.Fatalf("Validate should accept the previous code, got %d, %v", step, ok)
+	}
+	if _, ok := totp.Validate(secret, previous, now, step); ok {
+		t.Error("Validate should not accept a code twice")
+	}
+	stale, _ := totp.Code(secret, now.Add(-3*totp.Period))
+	if _, ok := totp.Validate(secret, stale, now, 0); ok {
+		t.Error("Validate should not accept a code from three periods ago")
+	}
+	if _, ok := totp.Validate(secret, "12345", now, 0); ok {
+		t.Error("Validate should not accept a short code")
+	}
+}
+
+func Test_URI(t *testing.T) {
+	uri := totp.URI("Chirpy", "jo@example.com", "ABC")
+	expected := "otpauth://totp/Chirpy:jo@example.com?algorithm=SHA1&digits=6&issuer=Chirpy&period=30&secret=ABC"
+	if uri != expected {
+		t.Errorf("URI = %s, expected %s", uri, expected)
+	}
+}
+
+func Test_RecoveryCodes(t *testing.T) {
+	codes, err := totp.GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes should not error: %s", err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Errorf("Unexpected recovery code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate recovery code %q", code)
+		}
+		seen[code] = true
+	}
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if totp.HashRecoveryCode(typed) != totp.HashRecoveryCode(codes[0]) {
+		t.Error("HashRecoveryCode should ignore case, dashes and spaces")
+	}
+}
@@ -0,0 +1,90 @@
+// Package webhook verifies signed webhook deliveries.
+//
+// The sender signs "<timestamp>.<body>" with HMAC-SHA256, where timestamp is
+// the delivery time in Unix seconds, and sends the hex encoded result as
+// "v1=<signature>". During a secret rotation it may send one signature per
+// secret, separated by commas.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const signaturePrefix = "v1="
+
+// DefaultTolerance is how far the delivery timestamp may be from our clock.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMissingSignature = errors.New("Missing signature or timestamp")
+	ErrStaleTimestamp   = errors.New("Timestamp outside of the tolerance window")
+	ErrInvalidSignature = errors.New("Invalid signature")
+)
+
+// Verifier accepts deliveries signed with any of its secrets, so that a new
+// secret can be rolled out before the old one is retired.
+type Verifier struct {
+	secrets   [][]byte
+	tolerance time.Duration
+}
+
+func NewVerifier(secrets []string, tolerance time.Duration) *Verifier {
+	verifier := &Verifier{tolerance: tolerance}
+	for _, secret := range secrets {
+		if secret != "" {
+			verifier.secrets = append(verifier.secrets, []byte(secret))
+		}
+	}
+	return verifier
+}
+
+// Sign returns the signature header value of body sent at timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac([]byte(secret), strconv.FormatInt(timestamp.Unix(), 10), body))
+}
+
+func mac(secret []byte, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verify checks the timestamp and signature headers of a delivery of body.
+// It returns the delivery time.
+func (v *Verifier) Verify(body []byte, timestampHeader, signatureHeader string, now time.Time) (time.Time, error) {
+	if timestampHeader == "" || signatureHeader == "" {
+		return time.Time{}, ErrMissingSignature
+	}
+	seconds, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrMissingSignature
+	}
+	timestamp := time.Unix(seconds, 0)
+	if timestamp.Before(now.Add(-v.tolerance)) || timestamp.After(now.Add(v.tolerance)) {
+		return time.Time{}, ErrStaleTimestamp
+	}
+	for _, signature := range strings.Split(signatureHeader, ",") {
+		signature = strings.TrimSpace(signature)
+		if !strings.HasPrefix(signature, signaturePrefix) {
+			continue
+		}
+		provided, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+		if err != nil {
+			continue
+		}
+		for _, secret := range v.secrets {
+			if hmac.Equal(provided, mac(secret, timestampHeader, body)) {
+				return timestamp, nil
+			}
+		}
+	}
+	return time.Time{}, ErrInvalidSignature
+}
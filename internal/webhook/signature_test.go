@@ -0,0 +1,96 @@
+package webhook_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/JP-Go/http-server-go/internal/webhook"
+)
+
+var body = []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+
+func timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func Test_VerifyAcceptsValidSignature(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{"secret"}, time.Minute)
+	now := time.Now()
+	if _, err := verifier.Verify(body, timestamp(now), webhook.Sign("secret", now, body), now); err != nil {
+		t.Errorf("Verify: %s", err)
+	}
+}
+
+func Test_VerifyRejectsTamperedBody(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{"secret"}, time.Minute)
+	now := time.Now()
+	signature := webhook.Sign("secret", now, body)
+	tampered := append([]byte{}, body...)
+	tampered[len(tampered)-3] = 'd'
+	if _, err := verifier.Verify(tampered, timestamp(now), signature, now); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("Verify = %v, expected %v", err, webhook.ErrInvalidSignature)
+	}
+}
+
+func Test_VerifyRejectsChangedTimestamp(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{"secret"}, time.Minute)
+	now := time.Now()
+	signature := webhook.Sign("secret", now.Add(-30*time.Second), body)
+	if _, err := verifier.Verify(body, timestamp(now), signature, now); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("Verify = %v, expected %v", err, webhook.ErrInvalidSignature)
+	}
+}
+
+func Test_VerifyRejectsTimestampOutsideTolerance(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{"secret"}, time.Minute)
+	now := time.Now()
+	for _, sentAt := range []time.Time{now.Add(-2 * time.Minute), now.Add(2 * time.Minute)} {
+		signature := webhook.Sign("secret", sentAt, body)
+		if _, err := verifier.Verify(body, timestamp(sentAt), signature, now); !errors.Is(err, webhook.ErrStaleTimestamp) {
+			t.Errorf("Verify = %v, expected %v", err, webhook.ErrStaleTimestamp)
+		}
+	}
+}
+
+func Test_VerifyAcceptsAnyActiveSecret(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{"new", "old"}, time.Minute)
+	now := time.Now()
+	for _, secret := range []string{"new", "old"} {
+		if _, err := verifier.Verify(body, timestamp(now), webhook.Sign(secret, now, body), now); err != nil {
+			t.Errorf("Verify with secret %s: %s", secret, err)
+		}
+	}
+	if _, err := verifier.Verify(body, timestamp(now), webhook.Sign("retired", now, body), now); err == nil {
+		t.Error("A retired secret should not be accepted")
+	}
+}
+
+func Test_VerifyAcceptsOneOfSeveralSignatures(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{"new"}, time.Minute)
+	now := time.Now()
+	header := webhook.Sign("old", now, body) + ", " + webhook.Sign("new", now, body)
+	if _, err := verifier.Verify(body, timestamp(now), header, now); err != nil {
+		t.Errorf("Verify: %s", err)
+	}
+}
+
+func Test_VerifyRejectsMissingHeaders(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{"secret"}, time.Minute)
+	now := time.Now()
+	if _, err := verifier.Verify(body, "", webhook.Sign("secret", now, body), now); !errors.Is(err, webhook.ErrMissingSignature) {
+		t.Errorf("Verify = %v, expected %v", err, webhook.ErrMissingSignature)
+	}
+	if _, err := verifier.Verify(body, timestamp(now), "", now); !errors.Is(err, webhook.ErrMissingSignature) {
+		t.Errorf("Verify = %v, expected %v", err, webhook.ErrMissingSignature)
+	}
+}
+
+func Test_VerifierWithoutSecretsRejectsEverything(t *testing.T) {
+	verifier := webhook.NewVerifier([]string{""}, time.Minute)
+	now := time.Now()
+	if _, err := verifier.Verify(body, timestamp(now), webhook.Sign("", now, body), now); err == nil {
+		t.Error("An empty secret should never verify")
+	}
+}
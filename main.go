@@ -1,17 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/JP-Go/http-server-go/internal/api"
+	"github.com/JP-Go/http-server-go/internal/auth"
 	"github.com/JP-Go/http-server-go/internal/database"
+	"github.com/JP-Go/http-server-go/internal/mailer"
+	"github.com/JP-Go/http-server-go/internal/media"
+	"github.com/JP-Go/http-server-go/internal/oidc"
+	"github.com/JP-Go/http-server-go/internal/password"
+	"github.com/JP-Go/http-server-go/internal/throttle"
+	"github.com/JP-Go/http-server-go/internal/webhook"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func MustLoadEnv(envVariable string) string {
@@ -28,14 +39,189 @@ func Must[T any](val T, err error) T {
 	return val
 }
 
+// loadKeyRing reads the asymmetric signing keys from JWT_KEYS_DIR. Without
+// it, tokens are signed with the JWT_SECRET shared secret. Startup fails when
+// neither is set, except with PLATFORM=dev, which signs with 'secret'.
+func loadKeyRing() *auth.KeyRing {
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		jwtSecret := os.Getenv("JWT_SECRET")
+		if jwtSecret == "" {
+			if os.Getenv("PLATFORM") != "dev" {
+				log.Fatal("Misconfigured environment. Set JWT_KEYS_DIR or JWT_SECRET")
+			}
+			fmt.Println("Empty JWT secret. Using the string 'secret' because PLATFORM=dev")
+			jwtSecret = "secret"
+		}
+		return auth.NewHMACKeyRing(jwtSecret)
+	}
+	var retired []string
+	if retiredKids := os.Getenv("JWT_RETIRED_KIDS"); retiredKids != "" {
+		retired = strings.Split(retiredKids, ",")
+	}
+	return Must(auth.LoadKeyRing(keysDir, MustLoadEnv("JWT_ACTIVE_KID"), retired))
+}
+
+// loadMailer sends email through the SMTP relay at SMTP_HOST. MAILER=log
+// prints emails to stdout instead, which is only meant for development: the
+// emails hold live verification and password reset links.
+func loadMailer() mailer.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <noreply@localhost>"
+	}
+	switch kind := os.Getenv("MAILER"); kind {
+	case "log":
+		fmt.Println("MAILER=log. Emails will be printed to stdout")
+		return mailer.NewLogMailer(from, os.Stdout)
+	case "", "smtp":
+	default:
+		log.Fatalf("Misconfigured environment. Unknown MAILER %s", kind)
+	}
+	smtpHost := MustLoadEnv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+	if smtpPort == "" {
+		smtpPort = "587"
+	}
+	return mailer.NewSMTPMailer(smtpHost, smtpPort, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+}
+
+// loadLoginPolicy lets LOGIN_LOCKOUT_THRESHOLD and LOGIN_LOCKOUT_DURATION (a
+// Go duration such as 15m) tune when an account is locked after wrong
+// passwords.
+func loadLoginPolicy() throttle.Policy {
+	policy := api.DefaultAccountLoginPolicy
+	if threshold := os.Getenv("LOGIN_LOCKOUT_THRESHOLD"); threshold != "" {
+		policy.LockoutThreshold = Must(strconv.Atoi(threshold))
+	}
+	if duration := os.Getenv("LOGIN_LOCKOUT_DURATION"); duration != "" {
+		policy.LockoutDuration = Must(time.ParseDuration(duration))
+	}
+	return policy
+}
+
+// loadPasswordPolicy reads PASSWORD_MIN_LENGTH, PASSWORD_MIN_SCORE (0 to 4)
+// and PWNED_PASSWORDS_DIR, a directory of Pwned Passwords range files to
+// reject breached passwords with.
+func loadPasswordPolicy() password.Policy {
+	policy := password.DefaultPolicy
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		policy.MinLength = Must(strconv.Atoi(minLength))
+	}
+	if minScore := os.Getenv("PASSWORD_MIN_SCORE"); minScore != "" {
+		policy.MinScore = password.Score(Must(strconv.Atoi(minScore)))
+	}
+	if pwnedDir := os.Getenv("PWNED_PASSWORDS_DIR"); pwnedDir != "" {
+		policy.Breaches = password.NewRangeDirChecker(pwnedDir)
+	}
+	return policy
+}
+
+// loadPasswordHasher picks the algorithm for new password hashes with
+// PASSWORD_HASH (argon2id or bcrypt). ARGON2_MEMORY_KIB, ARGON2_ITERATIONS,
+// ARGON2_PARALLELISM and BCRYPT_COST tune them. Existing hashes are upgraded
+// when their owners log in. PASSWORD_HASH_CONCURRENCY bounds how many hashes
+// run at once, which bounds the memory argon2id takes.
+func loadPasswordHasher() *auth.PasswordHasher {
+	hasher := loadPasswordAlgorithm()
+	if concurrency := os.Getenv("PASSWORD_HASH_CONCURRENCY"); concurrency != "" {
+		hasher.WithMaxConcurrency(Must(strconv.Atoi(concurrency)))
+	}
+	return hasher
+}
+
+func loadPasswordAlgorithm() *auth.PasswordHasher {
+	switch algorithm := os.Getenv("PASSWORD_HASH"); algorithm {
+	case "", auth.AlgorithmArgon2id:
+		params := auth.DefaultArgon2Params
+		if memory := os.Getenv("ARGON2_MEMORY_KIB"); memory != "" {
+			params.Memory = uint32(Must(strconv.ParseUint(memory, 10, 32)))
+		}
+		if iterations := os.Getenv("ARGON2_ITERATIONS"); iterations != "" {
+			params.Iterations = uint32(Must(strconv.ParseUint(iterations, 10, 32)))
+		}
+		if parallelism := os.Getenv("ARGON2_PARALLELISM"); parallelism != "" {
+			params.Parallelism = uint8(Must(strconv.ParseUint(parallelism, 10, 8)))
+		}
+		return auth.NewArgon2Hasher(params)
+	case auth.AlgorithmBcrypt:
+		cost := bcrypt.DefaultCost
+		if bcryptCost := os.Getenv("BCRYPT_COST"); bcryptCost != "" {
+			cost = Must(strconv.Atoi(bcryptCost))
+		}
+		return auth.NewBcryptHasher(cost)
+	default:
+		log.Fatalf("Misconfigured environment. Unknown PASSWORD_HASH %s", algorithm)
+		return nil
+	}
+}
+
+// loadOIDCProviders reads the identity providers listed in OIDC_PROVIDERS
+// (for example google,gitlab). Each one is configured by
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and
+// optionally OIDC_<NAME>_SCOPES, space separated.
+func loadOIDCProviders(baseURL string) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	names := os.Getenv("OIDC_PROVIDERS")
+	if names == "" {
+		return providers
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers[name] = oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       MustLoadEnv(prefix + "ISSUER"),
+			ClientID:     MustLoadEnv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  baseURL + "/api/auth/" + name + "/callback",
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}, nil)
+	}
+	return providers
+}
+
+// promoteAdmin makes the user with ADMIN_EMAIL an admin, which is how the
+// first admin gets created. The email has to be verified, and nobody is
+// promoted once an admin exists, so demoting that user through the API
+// sticks. Later admins can be appointed through the API.
+func promoteAdmin(queries *database.Queries) {
+	adminEmail := os.Getenv("ADMIN_EMAIL")
+	if adminEmail == "" {
+		return
+	}
+	promoted, err := queries.SetUserRoleByEmail(context.Background(), database.SetUserRoleByEmailParams{
+		Email: adminEmail,
+		Role:  auth.RoleAdmin,
+	})
+	if err != nil {
+		log.Fatalf("Could not promote %s to admin: %s", adminEmail, err)
+	}
+	if promoted > 0 {
+		fmt.Printf("Promoted %s to admin\n", adminEmail)
+	}
+}
+
+// loadPolkaWebhooks reads the secrets Polka signs webhooks with from
+// POLKA_WEBHOOK_SECRETS, comma separated so that a new secret can be added
+// before the old one is removed. POLKA_KEY is used when it is not set.
+// POLKA_WEBHOOK_TOLERANCE (a Go duration) bounds the age of deliveries.
+func loadPolkaWebhooks() *webhook.Verifier {
+	secrets := os.Getenv("POLKA_WEBHOOK_SECRETS")
+	if secrets == "" {
+		secrets = MustLoadEnv("POLKA_KEY")
+	}
+	tolerance := webhook.DefaultTolerance
+	if rawTolerance := os.Getenv("POLKA_WEBHOOK_TOLERANCE"); rawTolerance != "" {
+		tolerance = Must(time.ParseDuration(rawTolerance))
+	}
+	return webhook.NewVerifier(strings.Split(secrets, ","), tolerance)
+}
+
 func main() {
 	godotenv.Load()
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		fmt.Println("Empty JWT secret. Using the string 'secret'. THIS MUST NOT BE USED IN PRODUCTION")
-		jwtSecret = "secret"
-	}
+	keys := loadKeyRing()
 	portNumber := 8080
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -51,16 +237,40 @@ func main() {
 
 	dbUrl := MustLoadEnv("DB_URL")
 	db := Must(sql.Open("postgres", dbUrl))
+	queries := database.New(db)
+	promoteAdmin(queries)
+
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%d", portNumber)
+	}
+
+	// ACCOUNT_DELETION_GRACE_PERIOD is a Go duration such as 720h.
+	var gracePeriod time.Duration
+	if period := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); period != "" {
+		gracePeriod = Must(time.ParseDuration(period))
+	}
 
-	polkaApiKey := MustLoadEnv("POLKA_KEY")
+	// Uploads are served by the /app/ file server, so they must live below the
+	// working directory.
+	mediaDir := "media"
 
 	mux := http.NewServeMux()
 	fileServer := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
 
 	apiConfig := api.ApiConfig{
-		DB:          database.New(db),
-		PolkaApiKey: polkaApiKey,
-		JwtSecret:   jwtSecret,
+		DB:                         queries,
+		Conn:                       db,
+		Media:                      media.NewLocalStorage(mediaDir, "/app/"+mediaDir),
+		PolkaWebhooks:              loadPolkaWebhooks(),
+		Keys:                       keys,
+		Passwords:                  loadPasswordHasher(),
+		Mailer:                     loadMailer(),
+		BaseURL:                    baseURL,
+		OIDCProviders:              loadOIDCProviders(baseURL),
+		AccountDeletionGracePeriod: gracePeriod,
+		AccountLoginPolicy:         loadLoginPolicy(),
+		PasswordPolicy:             loadPasswordPolicy(),
 	}
 	chirpyApi := api.NewApi(&apiConfig)
 	chirpyApi.RegisterEndpoints(fileServer, mux)
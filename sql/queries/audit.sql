@@ -0,0 +1,6 @@
+-- name: CreateAuditLogEntry :exec
+INSERT INTO audit_log (id, actor_id, action, target_user_id, chirp_id, details, created_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, now());
+
+-- name: ListAuditLog :many
+SELECT * FROM audit_log ORDER BY created_at DESC LIMIT $1;
@@ -1,16 +1,79 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, user_id, body)
-VALUES (gen_random_uuid(),now(),now(),$1,$2) 
+INSERT INTO chirps (id, created_at, updated_at, user_id, body, reply_to)
+VALUES (gen_random_uuid(),now(),now(),$1,$2,$3) 
 RETURNING *;
 
 -- name: FindChirpByID :one
-SELECT * FROM chirps WHERE id = $1;
+SELECT * FROM chirps
+WHERE id = $1
+  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL);
 
--- name: FindChirpsFromUser :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
 
--- name: GetChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: SearchChirps :many
+SELECT chirps.*,
+    ts_rank(chirps.search_vector, query)::real AS rank,
+    ts_headline('english', translate(chirps.body, E'\x02\x03', ''), query, E'StartSel=\x02, StopSel=\x03')::text AS snippet
+FROM chirps, websearch_to_tsquery('english', sqlc.arg('query')) query
+WHERE chirps.search_vector @@ query
+  AND chirps.deleted_at IS NULL
+  AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND (sqlc.narg('author_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('author_id')::uuid)
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR CASE sqlc.arg('sort')::text
+        WHEN 'asc' THEN (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+        WHEN 'desc' THEN (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+        ELSE (ts_rank(chirps.search_vector, query)::real, chirps.created_at, chirps.id)
+            < (sqlc.narg('cursor_rank')::real, sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+      END)
+ORDER BY
+    CASE WHEN sqlc.arg('sort')::text = 'relevance' THEN ts_rank(chirps.search_vector, query)::real END DESC,
+    CASE WHEN sqlc.arg('sort')::text = 'asc' THEN chirps.created_at END ASC,
+    CASE WHEN sqlc.arg('sort')::text = 'asc' THEN chirps.id END ASC,
+    chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps WHERE id = $1;
+
+-- name: TombstoneChirp :execrows
+UPDATE chirps
+SET body = '',
+    deleted_at = now(),
+    updated_at = now()
+WHERE id = $1
+  AND EXISTS (SELECT 1 FROM chirps replies WHERE replies.reply_to = $1);
+
+-- name: GetReplyTree :many
+WITH RECURSIVE thread AS (
+    SELECT chirps.*, 1 AS depth
+    FROM chirps
+    WHERE chirps.reply_to = sqlc.arg('root_id')::uuid
+      AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+    UNION ALL
+    SELECT chirps.*, thread.depth + 1
+    FROM chirps
+    INNER JOIN thread ON chirps.reply_to = thread.id
+    WHERE thread.depth < sqlc.arg('max_depth')::int
+      AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+)
+SELECT * FROM thread
+ORDER BY depth ASC, created_at ASC, id ASC
+LIMIT 1000;
@@ -0,0 +1,7 @@
+-- name: ConsumeEmailToken :execrows
+INSERT INTO used_email_tokens (id, expires_at)
+VALUES ($1, $2)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: DeleteExpiredEmailTokens :exec
+DELETE FROM used_email_tokens WHERE expires_at < now();
@@ -0,0 +1,27 @@
+-- name: LikeChirp :exec
+INSERT INTO chirp_likes (user_id, chirp_id, created_at)
+VALUES ($1, $2, now())
+ON CONFLICT DO NOTHING;
+
+-- name: UnlikeChirp :exec
+DELETE FROM chirp_likes WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: RepostChirp :exec
+INSERT INTO chirp_reposts (user_id, chirp_id, created_at)
+VALUES ($1, $2, now())
+ON CONFLICT DO NOTHING;
+
+-- name: UndoRepostChirp :exec
+DELETE FROM chirp_reposts WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: GetChirpsEngagement :many
+SELECT chirps.id AS chirp_id,
+    (SELECT count(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id) AS like_count,
+    (SELECT count(*) FROM chirp_reposts WHERE chirp_reposts.chirp_id = chirps.id) AS repost_count,
+    (SELECT count(*) FROM chirps replies WHERE replies.reply_to = chirps.id AND replies.deleted_at IS NULL) AS reply_count,
+    EXISTS (
+        SELECT 1 FROM chirp_likes
+        WHERE chirp_likes.chirp_id = chirps.id AND chirp_likes.user_id = sqlc.narg('viewer_id')::uuid
+    ) AS liked_by_me
+FROM chirps
+WHERE chirps.id = ANY(sqlc.arg('chirp_ids')::uuid[]);
@@ -0,0 +1,59 @@
+-- name: AddChirpHashtags :exec
+INSERT INTO chirp_hashtags (chirp_id, tag, created_at)
+SELECT sqlc.arg('chirp_id')::uuid, tag, now()
+FROM unnest(sqlc.arg('tags')::text[]) AS tag
+ON CONFLICT DO NOTHING;
+
+-- name: AddChirpMentions :exec
+INSERT INTO chirp_mentions (chirp_id, user_id, created_at)
+SELECT sqlc.arg('chirp_id')::uuid, users.id, now()
+FROM users
+WHERE lower(users.handle) = ANY(sqlc.arg('handles')::text[])
+  AND users.deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+
+-- name: ClearChirpEntities :exec
+WITH cleared_hashtags AS (
+    DELETE FROM chirp_hashtags WHERE chirp_hashtags.chirp_id = $1
+)
+DELETE FROM chirp_mentions WHERE chirp_mentions.chirp_id = $1;
+
+-- name: ListMentionsForChirps :many
+SELECT chirp_mentions.chirp_id, users.id AS user_id, users.handle
+FROM chirp_mentions
+INNER JOIN users ON users.id = chirp_mentions.user_id
+WHERE chirp_mentions.chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+  AND users.deleted_at IS NULL;
+
+-- name: ListHashtagChirps :many
+SELECT chirps.* FROM chirps
+INNER JOIN chirp_hashtags ON chirp_hashtags.chirp_id = chirps.id
+WHERE chirp_hashtags.tag = sqlc.arg('tag')
+  AND chirps.deleted_at IS NULL
+  AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: ListMentionChirps :many
+SELECT chirps.* FROM chirps
+INNER JOIN chirp_mentions ON chirp_mentions.chirp_id = chirps.id
+WHERE chirp_mentions.user_id = sqlc.arg('user_id')
+  AND chirps.deleted_at IS NULL
+  AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+       OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: TrendingHashtags :many
+SELECT chirp_hashtags.tag, count(*) AS chirp_count
+FROM chirp_hashtags
+INNER JOIN chirps ON chirps.id = chirp_hashtags.chirp_id
+WHERE chirp_hashtags.created_at > now() - make_interval(hours => sqlc.arg('hours')::int)
+  AND chirps.deleted_at IS NULL
+  AND chirps.user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+GROUP BY chirp_hashtags.tag
+ORDER BY chirp_count DESC, chirp_hashtags.tag ASC
+LIMIT sqlc.arg('tag_limit');
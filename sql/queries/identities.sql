@@ -0,0 +1,19 @@
+-- name: CreateIdentity :one
+INSERT INTO identities (id, user_id, provider, subject, email, created_at, last_login_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, now(), now())
+RETURNING *;
+
+-- name: GetUserByIdentity :one
+SELECT users.* FROM identities
+INNER JOIN users ON identities.user_id = users.id
+WHERE identities.provider = $1 AND identities.subject = $2;
+
+-- name: TouchIdentity :exec
+UPDATE identities SET email = $3, last_login_at = now()
+WHERE provider = $1 AND subject = $2;
+
+-- name: ListUserIdentities :many
+SELECT * FROM identities WHERE user_id = $1 ORDER BY created_at;
+
+-- name: DeleteIdentity :execrows
+DELETE FROM identities WHERE user_id = $1 AND provider = $2;
@@ -0,0 +1,33 @@
+-- name: CreateMedia :one
+INSERT INTO media (id, created_at, user_id, content_type, storage_key, thumbnail_key, size_bytes, width, height)
+VALUES ($1, now(), $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: AttachMedia :execrows
+UPDATE media
+SET chirp_id = $1,
+    position = $2
+WHERE id = $3 AND user_id = $4 AND chirp_id IS NULL;
+
+-- name: ListMediaForChirps :many
+SELECT * FROM media
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, position ASC;
+
+-- name: CountAttachableMedia :one
+SELECT count(*) FROM media
+WHERE id = ANY(sqlc.arg('ids')::uuid[])
+  AND user_id = sqlc.arg('user_id')
+  AND chirp_id IS NULL;
+
+-- name: DeleteChirpMedia :many
+DELETE FROM media WHERE chirp_id = $1
+RETURNING storage_key, thumbnail_key;
+
+-- name: ListUserMediaKeys :many
+SELECT storage_key, thumbnail_key FROM media WHERE user_id = $1;
+
+-- name: DeleteUnattachedMedia :many
+DELETE FROM media
+WHERE chirp_id IS NULL AND created_at < sqlc.arg('uploaded_before')
+RETURNING storage_key, thumbnail_key;
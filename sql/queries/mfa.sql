@@ -0,0 +1,39 @@
+-- name: StartTOTPEnrollment :one
+INSERT INTO user_totp (user_id, secret, created_at)
+VALUES ($1, $2, now())
+ON CONFLICT (user_id) DO UPDATE
+SET secret = excluded.secret,
+    created_at = excluded.created_at,
+    last_used_step = 0
+WHERE user_totp.enabled_at IS NULL
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp WHERE user_id = $1;
+
+-- name: EnableTOTP :execrows
+UPDATE user_totp
+SET enabled_at = now(),
+    last_used_step = $2
+WHERE user_id = $1 AND enabled_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET last_used_step = $2
+WHERE user_id = $1 AND last_used_step < $2;
+
+-- name: DeleteTOTP :exec
+DELETE FROM user_totp WHERE user_id = $1;
+
+-- name: CreateRecoveryCodes :exec
+INSERT INTO recovery_codes (user_id, code_hash, created_at)
+SELECT sqlc.arg('user_id')::uuid, code_hash, now()
+FROM unnest(sqlc.arg('code_hashes')::text[]) AS code_hash;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = now()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
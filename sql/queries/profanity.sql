@@ -0,0 +1,10 @@
+-- name: ListProfanityRules :many
+SELECT * FROM profanity_rules ORDER BY created_at ASC, id ASC;
+
+-- name: CreateProfanityRule :one
+INSERT INTO profanity_rules (id, created_at, updated_at, pattern, match_type)
+VALUES (gen_random_uuid(), now(), now(), $1, $2)
+RETURNING *;
+
+-- name: DeleteProfanityRule :execrows
+DELETE FROM profanity_rules WHERE id = $1;
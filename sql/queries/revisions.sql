@@ -0,0 +1,17 @@
+-- name: EditChirp :one
+WITH previous AS (
+    INSERT INTO chirp_revisions (id, chirp_id, body, created_at)
+    SELECT gen_random_uuid(), chirps.id, chirps.body, now()
+    FROM chirps
+    WHERE chirps.id = sqlc.arg('id') AND chirps.deleted_at IS NULL
+)
+UPDATE chirps
+SET body = sqlc.arg('body'),
+    updated_at = now()
+WHERE chirps.id = sqlc.arg('id') AND chirps.deleted_at IS NULL
+RETURNING *;
+
+-- name: ListChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY created_at ASC, id ASC;
@@ -1,20 +1,69 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
-VALUES ($1, now(), now(), $2, $3)
-RETURNING token;
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address, last_used_at)
+VALUES ($1, now(), now(), $2, $3, $4, $5, $6, now())
+RETURNING token_hash;
 
 -- name: GetUserFromRefreshToken :one
 SELECT users.*, 
-    tokens.token, 
+    tokens.token_hash, 
     tokens.expires_at, 
-    tokens.revoked_at
+    tokens.revoked_at,
+    tokens.family_id,
+    tokens.rotated_at
 FROM refresh_tokens tokens
 INNER JOIN users ON tokens.user_id = users.id
-WHERE tokens.token = $1;
+WHERE tokens.token_hash = $1;
 
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens 
 SET 
     revoked_at = now(), 
     updated_at = now()
-WHERE token = $1;
+WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET
+    rotated_at = now(),
+    revoked_at = now(),
+    updated_at = now()
+WHERE token_hash = $1 AND rotated_at IS NULL AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET
+    revoked_at = now(),
+    updated_at = now()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: ListSessions :many
+SELECT
+    tokens.family_id,
+    (SELECT min(family.created_at) FROM refresh_tokens family WHERE family.family_id = tokens.family_id)::timestamp AS signed_in_at,
+    tokens.last_used_at,
+    tokens.expires_at,
+    tokens.user_agent,
+    tokens.ip_address
+FROM refresh_tokens tokens
+WHERE tokens.user_id = $1 AND tokens.revoked_at IS NULL AND tokens.expires_at > now()
+ORDER BY tokens.last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET
+    revoked_at = now(),
+    updated_at = now()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET
+    revoked_at = now(),
+    updated_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: SessionIsActive :one
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > now()
+);
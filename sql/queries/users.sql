@@ -1,6 +1,6 @@
 -- name: CreateUser :one
-INSERT INTO users ( id, created_at, updated_at, email, hashed_password) 
-VALUES (gen_random_uuid(), now(), now(), $1, $2)
+INSERT INTO users ( id, created_at, updated_at, email, hashed_password, handle, display_name, bio, avatar_url) 
+VALUES (gen_random_uuid(), now(), now(), $1, $2, $3, $4, $5, $6)
 RETURNING *;
 
 -- name: GetUserByEmail :one
@@ -9,12 +9,58 @@ SELECT * FROM users WHERE email = $1;
 -- name: GetUserByID :one
 SELECT * FROM users WHERE id = $1;
 
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE lower(handle) = lower(sqlc.arg('handle'));
+
+-- name: ListUsersByIDs :many
+SELECT * FROM users WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: GetUserStats :one
+SELECT
+    (SELECT count(*) FROM chirps WHERE chirps.user_id = sqlc.arg('user_id')::uuid AND chirps.deleted_at IS NULL) AS chirp_count,
+    (SELECT count(*) FROM follows WHERE follows.followee_id = sqlc.arg('user_id')::uuid
+        AND follows.follower_id IN (SELECT id FROM users WHERE deleted_at IS NULL)) AS follower_count,
+    (SELECT count(*) FROM follows WHERE follows.follower_id = sqlc.arg('user_id')::uuid
+        AND follows.followee_id IN (SELECT id FROM users WHERE deleted_at IS NULL)) AS following_count;
+
 -- name: UpdateUserCredentials :one
 UPDATE users 
-SET email = $1, 
-    hashed_password = $2, 
+SET email = sqlc.arg('email'), 
+    hashed_password = sqlc.arg('hashed_password'), 
+    token_version = token_version + 1,
+    email_verified_at = CASE WHEN email = sqlc.arg('email') THEN email_verified_at END,
+    updated_at = now()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: UpdateUserPassword :one
+UPDATE users
+SET hashed_password = $1,
+    token_version = token_version + 1,
     updated_at = now()
-WHERE id = $3
+WHERE id = $2
+RETURNING *;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg('new_hash')
+WHERE id = sqlc.arg('id') AND hashed_password = sqlc.arg('old_hash');
+
+-- name: VerifyUserEmail :one
+UPDATE users
+SET email_verified_at = coalesce(email_verified_at, now()),
+    updated_at = now()
+WHERE id = $1 AND email = $2
+RETURNING *;
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = coalesce(sqlc.narg('handle'), handle),
+    display_name = coalesce(sqlc.narg('display_name'), display_name),
+    bio = coalesce(sqlc.narg('bio'), bio),
+    avatar_url = coalesce(sqlc.narg('avatar_url'), avatar_url),
+    updated_at = now()
+WHERE id = sqlc.arg('id')
 RETURNING *;
 
 -- name: UpgradeChirpyRed :one
@@ -24,6 +70,53 @@ SET is_chirpy_red = $1,
 WHERE id = $2
 RETURNING *;
 
+-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1,
+    updated_at = now()
+WHERE id = $1
+RETURNING token_version;
+
+-- name: GetUserTokenVersion :one
+SELECT token_version FROM users WHERE id = $1;
+
 -- name: DeleteAllUsers :exec
 DELETE FROM users ;
 
+-- name: SetUserRole :one
+UPDATE users
+SET role = $2,
+    token_version = token_version + 1,
+    updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserRoleByEmail :execrows
+UPDATE users
+SET role = $2,
+    token_version = token_version + 1,
+    updated_at = now()
+WHERE email = $1
+    AND email_verified_at IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM users WHERE role = $2);
+
+-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = now(),
+    token_version = token_version + 1,
+    updated_at = now()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL,
+    updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: ListUsersToPurge :many
+SELECT id FROM users WHERE deleted_at < sqlc.arg('deleted_before')::timestamp;
+
+-- name: PurgeUser :execrows
+DELETE FROM users WHERE id = $1 AND deleted_at < sqlc.arg('deleted_before')::timestamp;
@@ -0,0 +1,10 @@
+-- name: RecordWebhookEvent :execrows
+INSERT INTO webhook_events (id, received_at)
+VALUES ($1, now())
+ON CONFLICT (id) DO NOTHING;
+
+-- name: ForgetWebhookEvent :exec
+DELETE FROM webhook_events WHERE id = $1;
+
+-- name: DeleteOldWebhookEvents :exec
+DELETE FROM webhook_events WHERE received_at < sqlc.arg('received_before')::timestamp;
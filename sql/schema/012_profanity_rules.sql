@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS profanity_rules (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    pattern TEXT NOT NULL,
+    match_type TEXT NOT NULL CHECK (match_type IN ('word', 'substring', 'regex')),
+    UNIQUE (pattern, match_type)
+);
+INSERT INTO profanity_rules (id, created_at, updated_at, pattern, match_type)
+VALUES
+    (gen_random_uuid(), now(), now(), 'kerfuffle', 'word'),
+    (gen_random_uuid(), now(), now(), 'sharbert', 'word'),
+    (gen_random_uuid(), now(), now(), 'fornax', 'word');
+
+-- +goose Down
+DROP TABLE profanity_rules;
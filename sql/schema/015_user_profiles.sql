@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN handle TEXT;
+UPDATE users SET handle = 'user_' || substr(replace(id::text, '-', ''), 1, 12);
+ALTER TABLE users ALTER COLUMN handle SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_handle_lower_idx ON users (lower(handle));
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN avatar_url;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN display_name;
+DROP INDEX IF EXISTS users_handle_lower_idx;
+ALTER TABLE users DROP COLUMN handle;
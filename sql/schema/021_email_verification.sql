@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS used_email_tokens (
+    id UUID PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE used_email_tokens;
+ALTER TABLE users DROP COLUMN email_verified_at;
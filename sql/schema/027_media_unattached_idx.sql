@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS media_unattached_created_at_idx ON media (created_at) WHERE chirp_id IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS media_unattached_created_at_idx;